@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zhangmingkai4315/hercules/utils"
+)
+
+// runBuild build the whole graph from prometheus configs without any
+// running agents and print it, so topology changes can be reviewed
+func runBuild(args []string) int {
+	fs := flag.NewFlagSet("build", flag.ContinueOnError)
+	hostsFile := fs.String("hosts", "", "Yaml file which map config file path to prometheus host")
+	root := fs.String("root", "", "Root prometheus host, needed when configs have many roots")
+	format := fs.String("format", "text", "Output format, text, json, dot or mermaid")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: hercules build [options] [host=]prometheus.yml|dir|glob ...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	configs, err := loadOfflineConfigs(*hostsFile, fs.Args())
+	if err == nil && len(configs) == 0 {
+		err = fmt.Errorf("no prometheus config found")
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+	node, err := utils.BuildGraphFromConfigs(configs, *root)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return printGraph(node, *format, false)
+}
+
+// printGraph print the graph in format text, json, dot or mermaid and
+// return the exit code, status is shown when withStatus is true
+func printGraph(node *utils.PrometheusNode, format string, withStatus bool) int {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(node)
+	case "text":
+		fmt.Println(strings.TrimPrefix(node.PrintNodesTree("  ", 0, withStatus), "\n"))
+	case "dot":
+		fmt.Print(utils.NewTopology(node).DOT(withStatus))
+	case "mermaid":
+		fmt.Print(utils.NewTopology(node).Mermaid(withStatus))
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown format %s\n", format)
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/zhangmingkai4315/hercules/utils"
+)
+
+// runExport fetch the graph from a running agent and print it
+// with status, same as /graph?format=dot or format=mermaid
+func runExport(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	agent := fs.String("agent", "localhost:19090", "Agent host and port to fetch the graph from")
+	format := fs.String("format", "dot", "Output format, dot, mermaid, text or json")
+	timeout := fs.Duration("timeout", 5*time.Second, "Timeout for fetching the graph")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: hercules export [options]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	node, err := utils.FetchGraph(*agent, *timeout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return printGraph(node, *format, true)
+}
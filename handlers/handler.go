@@ -1,8 +1,10 @@
 package handlers
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/zhangmingkai4315/hercules/utils"
@@ -16,15 +18,34 @@ func HealthCheckHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // RequestProxy will parse header and send the request based
-// header info
+// header info, the method, body, status code and headers are
+// kept between client and upstream, response is streamed.
+// Errors are replied in json with the failing hop
 func RequestProxy(w http.ResponseWriter, r *http.Request) {
-	resp, err := utils.MakePrometheusRequest(r)
+	start := time.Now()
+	resp, err := utils.ForwardPrometheusRequest(r)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		log.Error(err.Error())
-		fmt.Fprintf(w, "Error: %s", err)
+		writeProxyError(w, r, err, start)
 		return
 	}
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprint(w, resp)
+	defer resp.Body.Close()
+	utils.CopyHeader(w.Header(), resp.Header)
+	w.Header().Set(utils.AgentHeader, "hercules")
+	w.WriteHeader(resp.StatusCode)
+	if _, err := utils.StreamResponse(w, resp.Body, utils.MaxProxyResponseSize); err != nil {
+		log.Errorf("Stream proxy response fail: %s", err)
+		// status is sent already, abort the connection so
+		// client knows the response is not complete
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// writeProxyError reply the json error which identify the failing hop
+func writeProxyError(w http.ResponseWriter, r *http.Request, err error, start time.Time) {
+	log.Error(err.Error())
+	code, proxyErr := utils.NewProxyError(err, r, time.Since(start))
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set(utils.AgentHeader, "hercules")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(proxyErr)
 }
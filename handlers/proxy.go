@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/zhangmingkai4315/hercules/utils"
+)
+
+// ProxyHandler will return a http handler function which works like
+// RequestProxy, but when the target prometheus is given by header
+// X-Prometheus-Target or query target, the proxy chain is computed
+// from graph and request may be only the path on target
+func ProxyHandler(g *utils.Graph) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		if err := routeRequest(g, r); err != nil {
+			writeProxyError(w, r, err, start)
+			return
+		}
+		RequestProxy(w, r)
+	}
+}
+
+// routeRequest set the proxy headers of request from its target,
+// request without target is not changed
+func routeRequest(g *utils.Graph, r *http.Request) error {
+	target := r.Header.Get("X-Prometheus-Target")
+	if query := r.URL.Query(); target == "" && query.Get("target") != "" {
+		// target is only for current agent, keep the rest of query
+		target = query.Get("target")
+		query.Del("target")
+		r.URL.RawQuery = query.Encode()
+	}
+	if target == "" {
+		return nil
+	}
+	if r.Header.Get("X-Prometheus-Proxy") != "" {
+		return errors.New("X-Prometheus-Proxy must be empty when target is set")
+	}
+	request := r.Header.Get("X-Prometheus-Request")
+	if request == "" {
+		return errors.New("Proxy Chain Broken")
+	}
+	if strings.HasPrefix(request, "/") {
+		request = g.PrometheusURL(target) + request
+	}
+	proxy, err := g.ProxyRoute(target)
+	if err != nil {
+		return err
+	}
+	log.Debugf("Route request to %s by %s", target, proxy)
+	r.Header.Set("X-Prometheus-Proxy", proxy)
+	r.Header.Set("X-Prometheus-Request", request)
+	return nil
+}
@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zhangmingkai4315/hercules/utils"
+)
+
+// newProxyGraph return a graph which root federate a prometheus
+// server, the agent of prometheus is also a test server
+func newProxyGraph() (*utils.Graph, string, func()) {
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path + "?" + r.URL.RawQuery))
+	}))
+	agent := httptest.NewServer(http.HandlerFunc(RequestProxy))
+	prometheusHost := strings.TrimPrefix(prometheus.URL, "http://")
+	root, _ := utils.NewPrometheusNode("root-prometheus:9090")
+	child, _ := utils.NewPrometheusNode(prometheusHost)
+	child.AgentHost = strings.TrimPrefix(agent.URL, "http://")
+	root.Children = utils.PrometheusNodeList{child}
+	return utils.NewGraph(root), prometheusHost, func() {
+		prometheus.Close()
+		agent.Close()
+	}
+}
+
+func TestProxyHandlerWithTarget(t *testing.T) {
+	graph, target, closeServers := newProxyGraph()
+	defer closeServers()
+	handler := http.HandlerFunc(ProxyHandler(graph))
+
+	req, _ := http.NewRequest("GET", "/proxy", nil)
+	req.Header.Set("X-Prometheus-Target", target)
+	req.Header.Set("X-Prometheus-Request", "/api/v1/query?query=up")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if status := recorder.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if body := recorder.Body.String(); body != "/api/v1/query?query=up" {
+		t.Errorf("handler returned unexpected body: got %v want %v", body, "/api/v1/query?query=up")
+	}
+
+	req, _ = http.NewRequest("GET", "/proxy?target="+target, nil)
+	req.Header.Set("X-Prometheus-Request", target+"/api/v1/targets")
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	if body := recorder.Body.String(); body != "/api/v1/targets?" {
+		t.Errorf("handler returned unexpected body: got %v want %v", body, "/api/v1/targets?")
+	}
+}
+
+func TestRouteRequestWithScheme(t *testing.T) {
+	root, _ := utils.NewPrometheusNode("root-prometheus:9090")
+	child, _ := utils.NewPrometheusNode("secure-prometheus:9090")
+	child.AgentHost = "secure-agent:19090"
+	child.Federation = &utils.FederationEdge{Scheme: "https"}
+	root.Children = utils.PrometheusNodeList{child}
+	graph := utils.NewGraph(root)
+
+	req, _ := http.NewRequest("GET", "/proxy?target=secure-prometheus:9090", nil)
+	req.Header.Set("X-Prometheus-Request", "/federate")
+	if err := routeRequest(graph, req); err != nil {
+		t.Fatalf("Expect request routed, but got %s", err)
+	}
+	if request := req.Header.Get("X-Prometheus-Request"); request != "https://secure-prometheus:9090/federate" {
+		t.Errorf("Expect https request, but got %s", request)
+	}
+}
+
+func TestProxyHandlerWithBadTarget(t *testing.T) {
+	graph, target, closeServers := newProxyGraph()
+	defer closeServers()
+	handler := http.HandlerFunc(ProxyHandler(graph))
+	for _, header := range []map[string]string{
+		{"X-Prometheus-Target": "unknown:9090", "X-Prometheus-Request": "/api/v1/query?query=up"},
+		{"X-Prometheus-Target": target, "X-Prometheus-Request": ""},
+		{"X-Prometheus-Target": target, "X-Prometheus-Request": "/metrics", "X-Prometheus-Proxy": "agent:19090/proxy"},
+	} {
+		req, _ := http.NewRequest("GET", "/proxy", nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, req)
+		if status := recorder.Code; status != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestRequestProxyKeepRequestAndResponse(t *testing.T) {
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Method != "POST" || r.Form.Get("query") != "up" || r.URL.Query().Get("dedup") != "true" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte("compressed"))
+	}))
+	defer prometheus.Close()
+	agent := httptest.NewServer(http.HandlerFunc(RequestProxy))
+	defer agent.Close()
+
+	req, _ := http.NewRequest("POST", "/proxy?dedup=true", strings.NewReader("query=up"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept-Encoding", "gzip")
+	req.Header.Set("X-Prometheus-Proxy", strings.TrimPrefix(agent.URL, "http://")+"/proxy")
+	req.Header.Set("X-Prometheus-Request", strings.TrimPrefix(prometheus.URL, "http://")+"/api/v1/query")
+	recorder := httptest.NewRecorder()
+	http.HandlerFunc(RequestProxy).ServeHTTP(recorder, req)
+	if status := recorder.Code; status != http.StatusUnprocessableEntity {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusUnprocessableEntity)
+	}
+	if encoding := recorder.Header().Get("Content-Encoding"); encoding != "gzip" {
+		t.Errorf("handler returned wrong content encoding: got %v want gzip", encoding)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("handler returned wrong content type: got %v want application/json", contentType)
+	}
+	if body := recorder.Body.String(); body != "compressed" {
+		t.Errorf("handler returned unexpected body: got %v want compressed", body)
+	}
+}
+
+func TestRequestProxyWithUnreachableUpstream(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/proxy", nil)
+	req.Header.Set("X-Prometheus-Request", "127.0.0.1:1/api/v1/query")
+	recorder := httptest.NewRecorder()
+	http.HandlerFunc(RequestProxy).ServeHTTP(recorder, req)
+	if status := recorder.Code; status != http.StatusBadGateway {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadGateway)
+	}
+}
+
+func TestRequestProxyWithMaxResponseSize(t *testing.T) {
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/sized" {
+			w.Header().Set("Content-Length", "2048")
+		}
+		for i := 0; i < 2; i++ {
+			w.Write([]byte(strings.Repeat("x", 1024)))
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer prometheus.Close()
+	// restore the limit after agent is closed, the aborted handler may still read it
+	utils.MaxProxyResponseSize = 1500
+	defer func() { utils.MaxProxyResponseSize = 0 }()
+	agent := httptest.NewServer(http.HandlerFunc(RequestProxy))
+	defer agent.Close()
+
+	req, _ := http.NewRequest("GET", agent.URL, nil)
+	req.Header.Set("X-Prometheus-Request", strings.TrimPrefix(prometheus.URL, "http://")+"/sized")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("handler returned wrong status code: got %v want %v", resp.StatusCode, http.StatusBadGateway)
+	}
+	if proxyErr := decodeProxyError(t, body); proxyErr.UpstreamStatus != http.StatusOK {
+		t.Errorf("handler returned wrong upstream status: got %v want %v", proxyErr.UpstreamStatus, http.StatusOK)
+	}
+
+	req.Header.Set("X-Prometheus-Request", strings.TrimPrefix(prometheus.URL, "http://")+"/chunked")
+	// connection may be aborted before or after the status is sent
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	body, err = ioutil.ReadAll(resp.Body)
+	if err == nil {
+		t.Errorf("Expect response aborted, but got %d bytes", len(body))
+	}
+}
+
+func TestRequestProxyTimeoutAndCancel(t *testing.T) {
+	done := make(chan struct{}, 2)
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			done <- struct{}{}
+		case <-time.After(3 * time.Second):
+		}
+	}))
+	defer prometheus.Close()
+	agent := httptest.NewServer(http.HandlerFunc(RequestProxy))
+	defer agent.Close()
+	agentHost := strings.TrimPrefix(agent.URL, "http://")
+
+	// the last agent times out first and is reported as the failing hop
+	req, _ := http.NewRequest("GET", "/proxy", nil)
+	req.Header.Set("X-Prometheus-Proxy", agentHost+"/proxy")
+	req.Header.Set("X-Prometheus-Request", strings.TrimPrefix(prometheus.URL, "http://")+"/api/v1/query")
+	req.Header.Set(utils.TimeoutHeader, "0.5")
+	recorder := httptest.NewRecorder()
+	http.HandlerFunc(RequestProxy).ServeHTTP(recorder, req)
+	if status := recorder.Code; status != http.StatusGatewayTimeout {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusGatewayTimeout)
+	}
+	if body := recorder.Body.String(); !strings.Contains(body, "hop 2 ") {
+		t.Errorf("handler returned unexpected body: got %v want hop 2 timeout", body)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expect upstream request cancelled after timeout")
+	}
+
+	// client disconnect cancel the request in every hop
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	req, _ = http.NewRequest("GET", agent.URL, nil)
+	req.Header.Set("X-Prometheus-Request", strings.TrimPrefix(prometheus.URL, "http://")+"/api/v1/query")
+	if _, err := http.DefaultClient.Do(req.WithContext(ctx)); err == nil {
+		t.Fatal("Expect client request cancelled, but got nil")
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expect upstream request cancelled after client disconnect")
+	}
+}
+
+func decodeProxyError(t *testing.T, body []byte) *utils.ProxyError {
+	var proxyErr utils.ProxyError
+	if err := json.Unmarshal(body, &proxyErr); err != nil {
+		t.Fatalf("Expect json error, but got %s", body)
+	}
+	return &proxyErr
+}
+
+func TestRequestProxyErrorResponse(t *testing.T) {
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(3 * time.Second):
+		}
+	}))
+	defer prometheus.Close()
+	agent := httptest.NewServer(http.HandlerFunc(RequestProxy))
+	defer agent.Close()
+	agentHost := strings.TrimPrefix(agent.URL, "http://")
+	prometheusHost := strings.TrimPrefix(prometheus.URL, "http://")
+
+	req, _ := http.NewRequest("GET", "/proxy", nil)
+	req.Header.Set("X-Prometheus-Proxy", agentHost+"/proxy")
+	req.Header.Set("X-Prometheus-Request", prometheusHost+"/api/v1/query")
+	req.Header.Set(utils.TimeoutHeader, "0.3")
+	recorder := httptest.NewRecorder()
+	http.HandlerFunc(RequestProxy).ServeHTTP(recorder, req)
+	if status := recorder.Code; status != http.StatusGatewayTimeout {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusGatewayTimeout)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+		t.Errorf("handler returned wrong content type: got %v want application/json", contentType)
+	}
+	proxyErr := decodeProxyError(t, recorder.Body.Bytes())
+	if proxyErr.Status != "error" || proxyErr.ErrorType != "timeout" || proxyErr.Hop != 2 || proxyErr.Agent != prometheusHost {
+		t.Errorf("handler returned unexpected error: got %+v", proxyErr)
+	}
+	if proxyErr.Elapsed <= 0 {
+		t.Errorf("handler returned unexpected elapsed: got %v", proxyErr.Elapsed)
+	}
+
+	req, _ = http.NewRequest("GET", "/proxy", nil)
+	req.Header.Set("X-Prometheus-Proxy", "127.0.0.1:1/proxy")
+	req.Header.Set("X-Prometheus-Request", prometheusHost+"/api/v1/query")
+	recorder = httptest.NewRecorder()
+	http.HandlerFunc(RequestProxy).ServeHTTP(recorder, req)
+	if status := recorder.Code; status != http.StatusBadGateway {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadGateway)
+	}
+	proxyErr = decodeProxyError(t, recorder.Body.Bytes())
+	if proxyErr.ErrorType != "unavailable" || proxyErr.Hop != 1 || proxyErr.Agent != "127.0.0.1:1" {
+		t.Errorf("handler returned unexpected error: got %+v", proxyErr)
+	}
+
+	req, _ = http.NewRequest("GET", "/proxy", nil)
+	req.Header.Set(utils.HopHeader, "1")
+	recorder = httptest.NewRecorder()
+	http.HandlerFunc(RequestProxy).ServeHTTP(recorder, req)
+	if status := recorder.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+	proxyErr = decodeProxyError(t, recorder.Body.Bytes())
+	if proxyErr.ErrorType != "bad_data" || proxyErr.Hop != 1 {
+		t.Errorf("handler returned unexpected error: got %+v", proxyErr)
+	}
+}
+
+func TestRequestProxyNotAgentResponse(t *testing.T) {
+	prometheus := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"status":"error","errorType":"bad_data","error":"parse error"}`))
+	}))
+	defer prometheus.Close()
+	// a server on the agent address which is not an agent
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("<html>404 page not found</html>"))
+	}))
+	defer other.Close()
+	agent := httptest.NewServer(http.HandlerFunc(RequestProxy))
+	defer agent.Close()
+	agentHost := strings.TrimPrefix(agent.URL, "http://")
+	otherHost := strings.TrimPrefix(other.URL, "http://")
+	prometheusHost := strings.TrimPrefix(prometheus.URL, "http://")
+
+	req, _ := http.NewRequest("GET", "/proxy", nil)
+	req.Header.Set("X-Prometheus-Proxy", agentHost+"/proxy;"+otherHost+"/proxy")
+	req.Header.Set("X-Prometheus-Request", prometheusHost+"/api/v1/query")
+	recorder := httptest.NewRecorder()
+	http.HandlerFunc(RequestProxy).ServeHTTP(recorder, req)
+	if status := recorder.Code; status != http.StatusBadGateway {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadGateway)
+	}
+	proxyErr := decodeProxyError(t, recorder.Body.Bytes())
+	if proxyErr.ErrorType != "unavailable" || proxyErr.Hop != 2 || proxyErr.Agent != otherHost || proxyErr.UpstreamStatus != http.StatusNotFound {
+		t.Errorf("handler returned unexpected error: got %+v", proxyErr)
+	}
+
+	// error of prometheus is passed through every agent
+	req, _ = http.NewRequest("GET", "/proxy", nil)
+	req.Header.Set("X-Prometheus-Proxy", agentHost+"/proxy;"+agentHost+"/proxy")
+	req.Header.Set("X-Prometheus-Request", prometheusHost+"/api/v1/query")
+	recorder = httptest.NewRecorder()
+	http.HandlerFunc(RequestProxy).ServeHTTP(recorder, req)
+	if status := recorder.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+	if body := recorder.Body.String(); !strings.Contains(body, "parse error") {
+		t.Errorf("handler returned unexpected body: got %v want prometheus error", body)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/zhangmingkai4315/hercules/utils"
+)
+
+// runLint check the federation of prometheus configs offline and
+// return the exit code: 0 no error found, 1 errors found (or warnings
+// with -strict), 2 invalid arguments or configs
+func runLint(args []string) int {
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	hostsFile := fs.String("hosts", "", "Yaml file which map config file path to prometheus host")
+	format := fs.String("format", "text", "Output format, text or json")
+	strict := fs.Bool("strict", false, "Treat warnings as errors")
+	root := fs.String("root", "", "Root prometheus host for reachability check, needed when configs have many roots")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: hercules lint [options] [host=]prometheus.yml ...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	configs, err := loadOfflineConfigs(*hostsFile, fs.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+	problems, err := utils.Lint(configs, *root)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 2
+	}
+	switch *format {
+	case "json":
+		json.NewEncoder(os.Stdout).Encode(problems)
+	case "text":
+		for _, problem := range problems {
+			fmt.Println(problem)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown format %s\n", *format)
+		return 2
+	}
+	for _, problem := range problems {
+		if problem.Severity == utils.LintError || *strict {
+			return 1
+		}
+	}
+	return 0
+}
+
+// loadOfflineConfigs load the config args with the host mapping file
+func loadOfflineConfigs(hostsFile string, args []string) ([]*utils.OfflineConfig, error) {
+	mapping := map[string]string{}
+	if hostsFile != "" {
+		var err error
+		if mapping, err = utils.LoadHostMapping(hostsFile); err != nil {
+			return nil, err
+		}
+	}
+	configs, err := utils.ParseConfigArgs(args, mapping)
+	if err != nil {
+		return nil, err
+	}
+	return configs, utils.LoadOfflineConfigs(configs)
+}
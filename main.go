@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/zhangmingkai4315/hercules/handlers"
+	"github.com/zhangmingkai4315/hercules/ui"
 	"github.com/zhangmingkai4315/hercules/utils"
 )
 
@@ -17,13 +20,45 @@ var (
 	currentPrometheusHost string
 	agentPort             int
 	logLevel              string
+	pingInterval          time.Duration
+	pingTimeout           time.Duration
+	parentAgents          string
+	pushInterval          time.Duration
+	discoveryDepth        int
+	discoveryInterval     time.Duration
+	discoveryTimeout      time.Duration
+	agentMapping          string
+	watchInterval         time.Duration
+	configFromAPI         bool
+	storagePath           string
+	storageInterval       time.Duration
+	staleTTL              time.Duration
+	staleRemoveAfter      time.Duration
+	maxResponseSize       int64
+	proxyTimeout          time.Duration
 )
 
 func init() {
 	flag.StringVar(&prometheusConfig, "prometheus.config", "", "current prometheus config file")
+	flag.BoolVar(&configFromAPI, "prometheus.config.api", false, "Read the running config from prometheus host api instead of config file")
+	flag.DurationVar(&watchInterval, "prometheus.config.watch-interval", 10*time.Second, "Interval for checking config changes and service discovery refresh, 0 disable watching")
 	flag.StringVar(&currentPrometheusHost, "prometheus.host", "", "current prometheus host and port (for federation)")
 	flag.IntVar(&agentPort, "agent.port", 19090, "Agent port for connect with other")
+	flag.StringVar(&agentMapping, "agent.mapping", "", "Yaml file which map prometheus host to its agent host")
+	flag.StringVar(&storagePath, "storage.path", "", "File for saving the graph snapshot, empty disable saving")
+	flag.DurationVar(&storageInterval, "storage.interval", time.Minute, "Interval for saving the graph snapshot")
+	flag.DurationVar(&staleTTL, "graph.stale-ttl", 5*time.Minute, "Nodes not updated or seen for this time are marked stale, 0 disable")
+	flag.DurationVar(&staleRemoveAfter, "graph.stale-remove-after", 30*time.Minute, "Stale nodes are removed after this time")
 	flag.StringVar(&logLevel, "log.level", "warning", "Setting log level for program")
+	flag.DurationVar(&pingInterval, "ping.interval", 5*time.Second, "Interval for checking prometheus and agents status")
+	flag.DurationVar(&pingTimeout, "ping.timeout", 3*time.Second, "Timeout for each status check request")
+	flag.StringVar(&parentAgents, "agent.parents", "", "Comma separated parent agents (host:port) which current graph pushed to")
+	flag.DurationVar(&pushInterval, "push.interval", 30*time.Second, "Interval for pushing current graph to parent agents")
+	flag.IntVar(&discoveryDepth, "discovery.depth", 0, "Max depth for pulling graph from children agents, 0 disable pulling")
+	flag.DurationVar(&discoveryInterval, "discovery.interval", 30*time.Second, "Interval for pulling graph from children agents")
+	flag.Int64Var(&maxResponseSize, "proxy.max-response-size", 0, "Max bytes of proxied response body, larger response is aborted, 0 no limit")
+	flag.DurationVar(&proxyTimeout, "proxy.timeout", time.Minute, "Max time waiting for the response headers of next proxy hop, body is streamed without deadline, 0 leaves only the 5m transport limit")
+	flag.DurationVar(&discoveryTimeout, "discovery.timeout", 5*time.Second, "Timeout for each pulling request in every level")
 }
 
 func checkError(err error) {
@@ -45,35 +80,141 @@ func setLogLevel(level string) {
 	}
 }
 
+func saveGraph(graph *utils.Graph) {
+	if storagePath == "" {
+		return
+	}
+	if err := graph.Save(storagePath); err != nil {
+		log.Errorf("Save graph snapshot fail: %s", err)
+	}
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "lint":
+			os.Exit(runLint(os.Args[2:]))
+		case "build":
+			os.Exit(runBuild(os.Args[2:]))
+		case "export":
+			os.Exit(runExport(os.Args[2:]))
+		case "tree":
+			os.Exit(runTree(os.Args[2:]))
+		}
+	}
 	flag.Parse()
 	setLogLevel(logLevel)
-	if prometheusConfig == "" || currentPrometheusHost == "" {
+	if (prometheusConfig == "" && configFromAPI == false) || currentPrometheusHost == "" {
 		flag.Usage()
 		os.Exit(1)
 	}
 	node, err := utils.NewPrometheusNode(currentPrometheusHost)
 	checkError(err)
-	feds, err := utils.GetFederationHostsFromConfig(prometheusConfig)
+	resolver, err := utils.NewAgentResolver(agentPort, agentMapping)
 	checkError(err)
-	node.Children = utils.NewPrometheusNodeList(feds)
+	node.AgentHost = resolver.Resolve(utils.FederationTarget{PrometheusHost: currentPrometheusHost})
+	graph := utils.NewGraph(node)
+	graph.SetAgentResolver(resolver)
+	utils.MaxProxyResponseSize = maxResponseSize
+	utils.ProxyTimeout = proxyTimeout
+	var reloader *utils.Reloader
+	loaded := true
+	if configFromAPI {
+		// prometheus may not be ready yet, retry until config is read
+		reloader = utils.NewAPIReloader(graph, currentPrometheusHost, pingTimeout, resolver)
+		if err := reloader.Reload(); err != nil {
+			log.Errorf("Read config from prometheus fail: %s", err)
+			loaded = false
+		}
+	} else {
+		reloader = utils.NewReloader(graph, prometheusConfig, resolver)
+		checkError(reloader.Reload())
+	}
+	if storagePath != "" {
+		if saved, savedTime, err := utils.LoadGraphSnapshot(storagePath); err == nil {
+			log.Infof("Restore graph snapshot saved at %s", savedTime)
+			graph.Restore(saved)
+		} else if !os.IsNotExist(err) {
+			log.Errorf("Load graph snapshot fail: %s", err)
+		}
+	}
 	quit := make(chan struct{})
-	ticker := time.NewTicker(5 * time.Second)
+	if !loaded {
+		go reloader.RetryReload(time.Second, time.Minute, quit)
+	}
+	if watchInterval > 0 {
+		go reloader.Watch(watchInterval, quit)
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := reloader.Reload(); err != nil {
+				log.Errorf("Reload config fail: %s", err)
+			}
+		}
+	}()
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-term
+		saveGraph(graph)
+		os.Exit(0)
+	}()
+	ticker := time.NewTicker(pingInterval)
+	parents := utils.ParseParentHosts(parentAgents)
+	pushTicker := time.NewTicker(pushInterval)
+	if len(parents) == 0 {
+		pushTicker.Stop()
+	}
+	discoveryTicker := time.NewTicker(discoveryInterval)
+	if discoveryDepth <= 0 {
+		discoveryTicker.Stop()
+	}
+	storageTicker := time.NewTicker(storageInterval)
+	if storagePath == "" {
+		storageTicker.Stop()
+	}
 	go func() {
 		for {
 			select {
 			case <-ticker.C:
-				node.Ping()
+				graph.Ping(pingTimeout)
+				if staleTTL > 0 {
+					graph.Prune(staleTTL, staleRemoveAfter)
+				}
+			case <-pushTicker.C:
+				utils.PushGraphToParents(parents, graph.Snapshot(), pingTimeout)
+			case <-discoveryTicker.C:
+				graph.Discover(discoveryDepth, discoveryTimeout)
+			case <-storageTicker.C:
+				saveGraph(graph)
 			case <-quit:
 				ticker.Stop()
+				pushTicker.Stop()
+				discoveryTicker.Stop()
+				storageTicker.Stop()
 				return
 			}
 		}
 	}()
 
 	http.HandleFunc("/status", handlers.HealthCheckHandler)
-	http.HandleFunc("/proxy", handlers.RequestProxy)
-	http.HandleFunc("/graph", utils.GetGraph(node))
-	http.HandleFunc("/update-graph", utils.UpdateGraph(node))
+	http.HandleFunc("/proxy", handlers.ProxyHandler(graph))
+	http.HandleFunc("/graph", utils.GetGraph(graph))
+	http.HandleFunc("/update-graph", utils.UpdateGraph(graph))
+	http.HandleFunc("/graph/cycles", utils.GetCycles(graph))
+	http.HandleFunc("/graph/search", utils.SearchGraph(graph))
+	http.HandleFunc("/graph/path", utils.GetPath(graph))
+	http.HandleFunc("/graph/node", utils.GetNode(graph))
+	http.HandleFunc("/-/reload", utils.ReloadHandler(reloader))
+	http.Handle("/ui/", http.StripPrefix("/ui/", ui.Handler()))
+	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		http.Redirect(w, r, "/ui/", http.StatusFound)
+	})
 	http.ListenAndServe(fmt.Sprintf(":%d", agentPort), nil)
 }
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/zhangmingkai4315/hercules/utils"
+)
+
+// runTree fetch the graph from a running agent and print
+// it as a box-drawing tree with status of every node
+func runTree(args []string) int {
+	fs := flag.NewFlagSet("tree", flag.ContinueOnError)
+	agent := fs.String("agent", "localhost:19090", "Agent host and port to fetch the graph from")
+	timeout := fs.Duration("timeout", 5*time.Second, "Timeout for fetching the graph")
+	color := fs.Bool("color", false, "Show status with ANSI colors")
+	collapse := fs.Bool("collapse", false, "Collapse the subtrees which are all healthy")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: hercules tree [options]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	node, err := utils.FetchGraph(*agent, *timeout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	fmt.Print(node.RenderTree(utils.TreeOptions{Color: *color, CollapseHealthy: *collapse}))
+	return 0
+}
@@ -0,0 +1,213 @@
+(function () {
+  'use strict';
+
+  var defaultAgentPort = 19090;
+  var graph = null;
+  var expanded = {};
+  var matched = {};
+  var selected = null;
+
+  function $(id) { return document.getElementById(id); }
+
+  function key(path) { return path.join(' > '); }
+
+  function agentHost(node) {
+    if (node.agent_host) {
+      return node.agent_host;
+    }
+    return node.prometheus_host.replace(/:\d+$/, '') + ':' + defaultAgentPort;
+  }
+
+  function since(time) {
+    var t = Date.parse(time);
+    if (!t || t <= 0) {
+      return 'never';
+    }
+    var seconds = Math.round((Date.now() - t) / 1000);
+    if (seconds < 60) { return seconds + 's ago'; }
+    if (seconds < 3600) { return Math.round(seconds / 60) + 'm ago'; }
+    return Math.round(seconds / 3600) + 'h ago';
+  }
+
+  function badge(text, cls) {
+    var span = document.createElement('span');
+    span.className = 'badge ' + cls;
+    span.textContent = text;
+    return span;
+  }
+
+  function renderNode(node, path) {
+    var li = document.createElement('li');
+    var row = document.createElement('div');
+    var children = node.children || [];
+    var k = key(path);
+    row.className = 'row';
+    if (selected && key(selected.path) === k) { row.className += ' selected'; }
+    if (matched[k]) { row.className += ' matched'; }
+
+    var toggle = document.createElement('span');
+    toggle.className = 'toggle';
+    toggle.textContent = children.length === 0 ? '' : (expanded[k] ? '▾' : '▸');
+    toggle.onclick = function (e) {
+      e.stopPropagation();
+      expanded[k] = !expanded[k];
+      render();
+    };
+    row.appendChild(toggle);
+
+    var host = document.createElement('span');
+    host.className = 'host';
+    host.textContent = node.prometheus_host;
+    row.appendChild(host);
+    row.appendChild(badge('agent', node.agent_status ? 'ok' : 'error'));
+    row.appendChild(badge('prometheus', node.prometheus_status ? 'ok' : 'error'));
+    if (node.stale) { row.appendChild(badge('stale', 'stale')); }
+    var seen = document.createElement('span');
+    seen.className = 'seen';
+    seen.textContent = 'seen ' + since(node.last_seen);
+    row.appendChild(seen);
+    row.onclick = function () {
+      selected = { node: node, path: path };
+      render();
+      renderDetail();
+    };
+    li.appendChild(row);
+
+    if (children.length > 0 && expanded[k]) {
+      var ul = document.createElement('ul');
+      children.forEach(function (child) {
+        ul.appendChild(renderNode(child, path.concat(child.prometheus_host)));
+      });
+      li.appendChild(ul);
+    }
+    return li;
+  }
+
+  function render() {
+    var tree = $('tree');
+    tree.innerHTML = '';
+    if (!graph) { return; }
+    var ul = document.createElement('ul');
+    ul.appendChild(renderNode(graph, [graph.prometheus_host]));
+    tree.appendChild(ul);
+  }
+
+  function findNode(path) {
+    var node = graph;
+    for (var i = 1; node && i < path.length; i++) {
+      node = (node.children || []).filter(function (child) {
+        return child.prometheus_host === path[i];
+      })[0];
+    }
+    return node;
+  }
+
+  function renderDetail() {
+    if (!selected) { return; }
+    var node = findNode(selected.path) || selected.node;
+    $('detail').hidden = false;
+    $('detail-host').textContent = node.prometheus_host;
+    var rows = [
+      ['Agent', agentHost(node)],
+      ['Agent status', node.agent_status ? 'ok' : 'error'],
+      ['Prometheus status', node.prometheus_status ? 'ok' : 'error'],
+      ['Stale', node.stale ? 'yes' : 'no'],
+      ['Last updated', node.last_updated + ' (' + since(node.last_updated) + ')'],
+      ['Last seen', node.last_seen + ' (' + since(node.last_seen) + ')'],
+      ['Path', key(selected.path)]
+    ];
+    if (node.federation) {
+      rows.push(['Federate job', node.federation.job_name]);
+      rows.push(['Match', (node.federation.match || []).join(', ')]);
+    }
+    var table = $('detail-info');
+    table.innerHTML = '';
+    rows.forEach(function (r) {
+      var tr = document.createElement('tr');
+      [r[0], r[1]].forEach(function (text) {
+        var td = document.createElement('td');
+        td.textContent = text;
+        tr.appendChild(td);
+      });
+      table.appendChild(tr);
+    });
+  }
+
+  // query send the request through the agents on the path, the agent
+  // serving this page is the first hop so it is not in the proxy chain
+  function query(expr) {
+    var path = selected.path;
+    var chain = [];
+    for (var i = 1; i < path.length; i++) {
+      chain.push(agentHost(findNode(path.slice(0, i + 1))) + '/proxy');
+    }
+    var target = path[path.length - 1] + '/api/v1/query?query=' + encodeURIComponent(expr);
+    $('query-result').textContent = 'Loading...';
+    fetch('/proxy', {
+      headers: {
+        'X-Prometheus-Proxy': chain.join(';'),
+        'X-Prometheus-Request': target
+      }
+    }).then(function (resp) {
+      return resp.text();
+    }).then(function (text) {
+      try {
+        text = JSON.stringify(JSON.parse(text), null, 2);
+      } catch (e) {}
+      $('query-result').textContent = text;
+    }).catch(function (err) {
+      $('query-result').textContent = 'Error: ' + err;
+    });
+  }
+
+  function search(host) {
+    matched = {};
+    $('message').textContent = '';
+    if (!host) {
+      render();
+      return;
+    }
+    fetch('/graph/search?host=' + encodeURIComponent(host)).then(function (resp) {
+      return resp.json();
+    }).then(function (result) {
+      if (!result.found) {
+        $('message').textContent = host + ' not found';
+      }
+      result.paths.forEach(function (path) {
+        matched[key(path)] = true;
+        for (var i = 1; i < path.length; i++) {
+          expanded[key(path.slice(0, i))] = true;
+        }
+      });
+      render();
+    }).catch(function (err) {
+      $('message').textContent = 'Error: ' + err;
+    });
+  }
+
+  function load() {
+    fetch('/graph').then(function (resp) {
+      return resp.json();
+    }).then(function (root) {
+      if (!graph) {
+        expanded[key([root.prometheus_host])] = true;
+      }
+      graph = root;
+      render();
+      renderDetail();
+    }).catch(function (err) {
+      $('message').textContent = 'Error: ' + err;
+    });
+  }
+
+  $('search').onsubmit = function (e) {
+    e.preventDefault();
+    search($('search-host').value.trim());
+  };
+  $('query').onsubmit = function (e) {
+    e.preventDefault();
+    if (selected) { query($('query-expr').value); }
+  };
+  load();
+  setInterval(load, 10000);
+})();
@@ -0,0 +1,22 @@
+// Package ui serve the embedded web page for browsing
+// the federation graph of agent
+package ui
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed static
+var static embed.FS
+
+// Handler return the http handler serving the web ui files,
+// it should be mounted with the prefix stripped
+func Handler() http.Handler {
+	files, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(files))
+}
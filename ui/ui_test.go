@@ -0,0 +1,26 @@
+package ui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	handler := http.StripPrefix("/ui/", Handler())
+	for _, path := range []string{"/ui/", "/ui/app.js", "/ui/style.css"} {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		handler.ServeHTTP(recorder, req)
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expect status code 200 for %s, but got %d", path, recorder.Code)
+		}
+	}
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/ui/", nil)
+	handler.ServeHTTP(recorder, req)
+	if !strings.Contains(recorder.Body.String(), "<title>Hercules</title>") {
+		t.Fatalf("Expect index page, but got %s", recorder.Body.String())
+	}
+}
@@ -0,0 +1,82 @@
+package utils
+
+import (
+	"io/ioutil"
+	"net"
+	"strconv"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// AgentResolver find the agent address of a prometheus host, the explicit
+// mapping is used first, then the agent label on federation target, at last
+// the convention which agent running on the same host with Port
+type AgentResolver struct {
+	Port    int
+	Mapping map[string]string
+}
+
+// NewAgentResolver create a resolver with agent port and mapping file,
+// mapping file is optional and should be a yaml map of prometheus host
+// to agent host
+func NewAgentResolver(port int, mappingFile string) (*AgentResolver, error) {
+	resolver := &AgentResolver{Port: port, Mapping: map[string]string{}}
+	if mappingFile == "" {
+		return resolver, nil
+	}
+	data, err := ioutil.ReadFile(mappingFile)
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(data, &resolver.Mapping); err != nil {
+		return nil, err
+	}
+	return resolver, nil
+}
+
+// Resolve return the agent address of federation target
+func (ar *AgentResolver) Resolve(target FederationTarget) string {
+	if agent, ok := ar.Mapping[target.PrometheusHost]; ok && agent != "" {
+		return agent
+	}
+	if target.AgentHost != "" {
+		return target.AgentHost
+	}
+	host, _, err := net.SplitHostPort(target.PrometheusHost)
+	if err != nil {
+		host = target.PrometheusHost
+	}
+	port := ar.Port
+	if port == 0 {
+		port = DefaultAgentPort
+	}
+	return net.JoinHostPort(host, strconv.Itoa(port))
+}
+
+// ResolveTree set the agent host of every node in the tree which has
+// no agent host yet, nodes from other agents keep their own setting
+func (ar *AgentResolver) ResolveTree(pn *PrometheusNode) {
+	if ar == nil {
+		return
+	}
+	if pn.AgentHost == "" {
+		pn.AgentHost = ar.Resolve(FederationTarget{PrometheusHost: pn.PrometheusHost})
+	}
+	for _, child := range pn.Children {
+		ar.ResolveTree(child)
+	}
+}
+
+// NewPrometheusNodeListWithAgent create nodes list, set agent host
+// of each node by resolver and the federation edge from target
+func NewPrometheusNodeListWithAgent(targets []FederationTarget, resolver *AgentResolver) PrometheusNodeList {
+	var pnl PrometheusNodeList
+	for _, target := range targets {
+		if pn, err := NewPrometheusNode(target.PrometheusHost); err == nil {
+			pn.AgentHost = resolver.Resolve(target)
+			pn.Federation = target.Edge()
+			pnl = append(pnl, pn)
+		}
+	}
+	return pnl
+}
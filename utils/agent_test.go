@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetFederationTargetsWithAgentLabel(t *testing.T) {
+	targets, err := GetFederationTargetsFromConfig("testdata/prometheus.agent.conf")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, len(targets))
+	assert.Equal(t, "source-prometheus-3:9090", targets[2].PrometheusHost)
+	assert.Equal(t, "agent-3:29090", targets[2].AgentHost)
+	assert.Equal(t, "", targets[0].AgentHost)
+}
+
+func TestAgentResolver(t *testing.T) {
+	resolver, err := NewAgentResolver(29090, "testdata/agents.yml")
+	assert.Nil(t, err)
+	targets, _ := GetFederationTargetsFromConfig("testdata/prometheus.agent.conf")
+	expect := []string{"agent-1:19090", "agent-2:8080", "agent-3:29090", "source-prometheus-4:29090"}
+	for index, target := range targets {
+		assert.Equal(t, expect[index], resolver.Resolve(target))
+	}
+
+	pnl := NewPrometheusNodeListWithAgent(targets, resolver)
+	assert.Equal(t, len(targets), len(pnl))
+	assert.Equal(t, "agent-2:8080", pnl[1].AgentHost)
+
+	resolver, err = NewAgentResolver(0, "")
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.1:19090", resolver.Resolve(FederationTarget{PrometheusHost: "10.0.0.1:9090"}))
+
+	_, err = NewAgentResolver(19090, "testdata/not-exist.yml")
+	assert.NotNil(t, err)
+}
+
+func TestGetGraphWithFederationEdge(t *testing.T) {
+	resolver, _ := NewAgentResolver(19090, "")
+	targets, _ := GetFederationTargetsFromConfig("testdata/prometheus.agent.conf")
+	node, _ := NewPrometheusNode(rootHost)
+	node.Children = NewPrometheusNodeListWithAgent(targets, resolver)
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/graph", nil)
+	GetGraph(NewGraph(node))(recorder, req)
+
+	var response PrometheusNode
+	err := json.Unmarshal(recorder.Body.Bytes(), &response)
+	assert.Nil(t, err)
+	assert.Nil(t, response.Federation)
+	edge := response.Children[0].Federation
+	assert.NotNil(t, edge)
+	assert.Equal(t, "federate", edge.JobName)
+	assert.Equal(t, "15s", edge.ScrapeInterval)
+	assert.Equal(t, []string{`{job="prometheus"}`}, edge.Match)
+	assert.True(t, edge.HonorLabels)
+}
+
+func TestGraphResolveAgentHost(t *testing.T) {
+	resolver, _ := NewAgentResolver(29090, "testdata/agents.yml")
+	nodeRoot, _ := NewPrometheusNode(rootHost)
+	graph := NewGraph(nodeRoot)
+	graph.SetAgentResolver(resolver)
+
+	pushed, _ := NewPrometheusNode("source-prometheus-5:9090")
+	pushed.Children = NewPrometheusNodeList([]string{"source-prometheus-51:9090", "source-prometheus-52:9090"})
+	pushed.Children[1].AgentHost = "agent-52:8080"
+	assert.Nil(t, graph.InsertOrUpdate(pushed, true))
+
+	node := graph.Snapshot().Children[0]
+	assert.Equal(t, "source-prometheus-5:29090", node.GetAgentHost())
+	assert.Equal(t, "source-prometheus-51:29090", node.Children[0].GetAgentHost())
+	assert.Equal(t, "agent-52:8080", node.Children[1].GetAgentHost())
+}
@@ -0,0 +1,178 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxCycleReports is the max number of refused cycles kept by graph
+const maxCycleReports = 100
+
+// CycleError is returned when merging a subtree would make a federation cycle
+type CycleError struct {
+	Cycle []string
+}
+
+func (e *CycleError) Error() string {
+	return "federation cycle found: " + strings.Join(e.Cycle, " -> ")
+}
+
+// CycleReport is a cycle refused when merging subtree
+type CycleReport struct {
+	Cycle []string  `json:"cycle"`
+	Time  time.Time `json:"time"`
+}
+
+// FindCycle return the first cycle found in the subtree of current node,
+// ancestors is the hosts from root to the parent of current node
+func (pn *PrometheusNode) FindCycle(ancestors []string) []string {
+	for index, host := range ancestors {
+		if host == pn.PrometheusHost {
+			return append(append([]string{}, ancestors[index:]...), host)
+		}
+	}
+	path := append(append([]string{}, ancestors...), pn.PrometheusHost)
+	for _, child := range pn.Children {
+		if cycle := child.FindCycle(path); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// ancestorsOf return the hosts from current node to the parent
+// of every node matched the host
+func (pn *PrometheusNode) ancestorsOf(host string) [][]string {
+	paths := [][]string{}
+	var walk func(node *PrometheusNode, path []string)
+	walk = func(node *PrometheusNode, path []string) {
+		path = append(append([]string{}, path...), node.PrometheusHost)
+		for _, child := range node.Children {
+			if child.PrometheusHost == host {
+				paths = append(paths, path)
+				continue
+			}
+			walk(child, path)
+		}
+	}
+	walk(pn, nil)
+	return paths
+}
+
+// checkInsertCycle return error if insert new node into the tree
+// would make any host become its own ancestor
+func (pn *PrometheusNode) checkInsertCycle(newNode *PrometheusNode, search bool) error {
+	paths := pn.ancestorsOf(newNode.PrometheusHost)
+	if search && pn.Search(newNode.PrometheusHost, false) == false {
+		paths = [][]string{{pn.PrometheusHost}}
+	}
+	for _, path := range paths {
+		if cycle := newNode.FindCycle(path); cycle != nil {
+			return &CycleError{Cycle: cycle}
+		}
+	}
+	return nil
+}
+
+// FindCycles return all cycles in the topology,
+// every cycle start and end with the same host
+func (t *Topology) FindCycles() [][]string {
+	children := map[string][]string{}
+	for _, edge := range t.Edges {
+		children[edge.Parent] = append(children[edge.Parent], edge.Child)
+	}
+	hosts := []string{}
+	for host := range t.Nodes {
+		if host != t.Root {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	// start from root so cycles are reported from the top
+	if _, ok := t.Nodes[t.Root]; ok {
+		hosts = append([]string{t.Root}, hosts...)
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	cycles := [][]string{}
+	found := map[string]bool{}
+	state := map[string]int{}
+	stack := []string{}
+	var visit func(host string)
+	visit = func(host string) {
+		state[host] = visiting
+		stack = append(stack, host)
+		for _, child := range children[host] {
+			switch state[child] {
+			case visiting:
+				for index := len(stack) - 1; index >= 0; index-- {
+					if stack[index] == child {
+						cycle := append(append([]string{}, stack[index:]...), child)
+						if key := cycleKey(cycle); found[key] == false {
+							found[key] = true
+							cycles = append(cycles, cycle)
+						}
+						break
+					}
+				}
+			case unvisited:
+				visit(child)
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[host] = visited
+	}
+	for _, host := range hosts {
+		if state[host] == unvisited {
+			visit(host)
+		}
+	}
+	return cycles
+}
+
+// cycleKey return the same key for a cycle start from any host
+func cycleKey(cycle []string) string {
+	hosts := cycle[:len(cycle)-1]
+	start := 0
+	for index, host := range hosts {
+		if host < hosts[start] {
+			start = index
+		}
+	}
+	return strings.Join(append(append([]string{}, hosts[start:]...), hosts[:start]...), ";")
+}
+
+func (g *Graph) recordCycle(cycle []string) {
+	g.cycles = append(g.cycles, CycleReport{Cycle: cycle, Time: time.Now()})
+	if len(g.cycles) > maxCycleReports {
+		g.cycles = g.cycles[len(g.cycles)-maxCycleReports:]
+	}
+}
+
+// Cycles return the cycles refused before and the cycles in current topology
+func (g *Graph) Cycles() ([]CycleReport, [][]string) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return append([]CycleReport{}, g.cycles...), g.topology(g.edges).FindCycles()
+}
+
+// GetCycles will return a http handler function which list
+// the refused cycles and the cycles in current topology
+func GetCycles(g *Graph) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		refused, current := g.Cycles()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"refused": refused,
+			"current": current,
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusNodeFindCycle(t *testing.T) {
+	node, _ := NewPrometheusNode(fedsRoot[0])
+	node.Children = NewPrometheusNodeList(fedsChild11)
+	assert.Nil(t, node.FindCycle([]string{rootHost}))
+
+	node.Children[1].Children = NewPrometheusNodeList([]string{rootHost})
+	assert.Equal(t, []string{rootHost, fedsRoot[0], fedsChild11[1], rootHost}, node.FindCycle([]string{rootHost}))
+
+	node.Children[1].Children = NewPrometheusNodeList([]string{fedsRoot[0]})
+	assert.Equal(t, []string{fedsRoot[0], fedsChild11[1], fedsRoot[0]}, node.FindCycle(nil))
+}
+
+func TestPrometheusNodeInsertOrUpdateWithCycle(t *testing.T) {
+	nodeRoot, _ := NewPrometheusNode(rootHost)
+	nodeRoot.Children = NewPrometheusNodeList(fedsRoot)
+	nodeRoot.Children[0].Children = NewPrometheusNodeList(fedsChild11)
+
+	// source-prometheus-11 federate source-prometheus-1 which is its parent
+	node11, _ := NewPrometheusNode(fedsChild11[0])
+	node11.Children = NewPrometheusNodeList([]string{fedsRoot[0]})
+	err := nodeRoot.InsertOrUpdate(node11, false)
+	assert.NotNil(t, err)
+	cycleErr, ok := err.(*CycleError)
+	assert.True(t, ok)
+	assert.Equal(t, []string{fedsRoot[0], fedsChild11[0], fedsRoot[0]}, cycleErr.Cycle)
+	assert.Equal(t, "federation cycle found: source-prometheus-1:9090 -> source-prometheus-11:9090 -> source-prometheus-1:9090", err.Error())
+	assert.Equal(t, 0, len(nodeRoot.Children[0].Children[0].Children))
+
+	node11.Children = NewPrometheusNodeList(fedsChild111)
+	assert.Nil(t, nodeRoot.InsertOrUpdate(node11, false))
+	assert.True(t, nodeRoot.Search(fedsChild111[0], true))
+
+	// new child federate the root
+	newNode, _ := NewPrometheusNode("new-prometheus:9090")
+	newNode.Children = NewPrometheusNodeList([]string{rootHost})
+	assert.NotNil(t, nodeRoot.InsertOrUpdate(newNode, true))
+	assert.False(t, nodeRoot.Search("new-prometheus:9090", false))
+}
+
+func TestTopologyFindCycles(t *testing.T) {
+	nodeRoot, _ := NewPrometheusNode(rootHost)
+	nodeRoot.Children = NewPrometheusNodeList(fedsRoot)
+	assert.Equal(t, [][]string{}, NewTopology(nodeRoot).FindCycles())
+
+	// cycles come from nodes built before cycle checking
+	nodeRoot.Children[0].Children = NewPrometheusNodeList([]string{fedsRoot[1]})
+	nodeRoot.Children[1].Children = NewPrometheusNodeList([]string{fedsRoot[0]})
+	nodeRoot.Children[2].Children = NewPrometheusNodeList([]string{rootHost})
+	cycles := NewTopology(nodeRoot).FindCycles()
+	assert.Equal(t, 2, len(cycles))
+	assert.Contains(t, cycles, []string{fedsRoot[0], fedsRoot[1], fedsRoot[0]})
+	assert.Contains(t, cycles, []string{rootHost, fedsRoot[2], rootHost})
+}
+
+func TestUpdateGraphWithCycle(t *testing.T) {
+	nodeRoot, _ := NewPrometheusNode(rootHost)
+	nodeRoot.Children = NewPrometheusNodeList(fedsRoot)
+	graph := NewGraph(nodeRoot)
+	handler := http.HandlerFunc(UpdateGraph(graph))
+
+	body := `{"prometheus_host": "source-prometheus-1:9090", "children": [{"prometheus_host": "source-prometheus:9090"}]}`
+	req, _ := http.NewRequest("POST", "/update-graph", bytes.NewBufferString(body))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), "federation cycle found")
+	assert.False(t, graph.Search(rootHost, true))
+
+	req, _ = http.NewRequest("GET", "/graph/cycles", nil)
+	recorder = httptest.NewRecorder()
+	http.HandlerFunc(GetCycles(graph)).ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var response struct {
+		Refused []CycleReport `json:"refused"`
+		Current [][]string    `json:"current"`
+	}
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	assert.Equal(t, 1, len(response.Refused))
+	assert.Equal(t, []string{rootHost, fedsRoot[0], rootHost}, response.Refused[0].Cycle)
+	assert.Equal(t, 0, len(response.Current))
+}
@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"sort"
+	"time"
+)
+
+// TopologyNode is a prometheus server in the federation topology,
+// a server federated by many parents only has one node
+type TopologyNode struct {
+	PrometheusHost   string    `json:"prometheus_host"`
+	AgentHost        string    `json:"agent_host"`
+	AgentStatus      bool      `json:"agent_status"`
+	PrometheusStatus bool      `json:"prometheus_status"`
+	LastUpdated      time.Time `json:"last_updated"`
+	LastSeen         time.Time `json:"last_seen"`
+	Stale            bool      `json:"stale"`
+	Parents          []string  `json:"parents"`
+	Children         []string  `json:"children"`
+}
+
+// TopologyEdge is the federation from parent to child
+type TopologyEdge struct {
+	Parent     string          `json:"parent"`
+	Child      string          `json:"child"`
+	Federation *FederationEdge `json:"federation,omitempty"`
+}
+
+// Topology is the federation graph which nodes keyed by
+// host and edges stored separately
+type Topology struct {
+	Root  string                   `json:"root"`
+	Nodes map[string]*TopologyNode `json:"nodes"`
+	Edges []TopologyEdge           `json:"edges"`
+}
+
+// NewTopology build the topology from node tree, if a host appear many
+// times in the tree, the status of the latest active one is used
+func NewTopology(root *PrometheusNode) *Topology {
+	topology := &Topology{
+		Root:  root.PrometheusHost,
+		Nodes: map[string]*TopologyNode{},
+		Edges: []TopologyEdge{},
+	}
+	edges := map[[2]string]bool{}
+	topology.addNode(root)
+	var walk func(parent *PrometheusNode)
+	walk = func(parent *PrometheusNode) {
+		for _, child := range parent.Children {
+			topology.addNode(child)
+			key := [2]string{parent.PrometheusHost, child.PrometheusHost}
+			if edges[key] == false {
+				edges[key] = true
+				topology.Edges = append(topology.Edges, TopologyEdge{
+					Parent:     parent.PrometheusHost,
+					Child:      child.PrometheusHost,
+					Federation: child.Federation,
+				})
+			}
+			walk(child)
+		}
+	}
+	walk(root)
+	for _, edge := range topology.Edges {
+		topology.Nodes[edge.Parent].Children = append(topology.Nodes[edge.Parent].Children, edge.Child)
+		topology.Nodes[edge.Child].Parents = append(topology.Nodes[edge.Child].Parents, edge.Parent)
+	}
+	for _, node := range topology.Nodes {
+		sort.Strings(node.Parents)
+		sort.Strings(node.Children)
+	}
+	return topology
+}
+
+func (t *Topology) addNode(pn *PrometheusNode) {
+	node, ok := t.Nodes[pn.PrometheusHost]
+	if ok && !pn.LastActive().After(latest(node.LastUpdated, node.LastSeen)) {
+		if node.AgentHost == "" {
+			node.AgentHost = pn.AgentHost
+		}
+		return
+	}
+	newNode := &TopologyNode{
+		PrometheusHost:   pn.PrometheusHost,
+		AgentHost:        pn.AgentHost,
+		AgentStatus:      pn.AgentStatus,
+		PrometheusStatus: pn.PrometheusStatus,
+		LastUpdated:      pn.LastUpdated,
+		LastSeen:         pn.LastSeen,
+		Stale:            pn.Stale,
+	}
+	if ok && newNode.AgentHost == "" {
+		newNode.AgentHost = node.AgentHost
+	}
+	t.Nodes[pn.PrometheusHost] = newNode
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// topology build the topology from nodes and the given edges of graph,
+// the edges may reach hosts not stored yet when checking new edges
+func (g *Graph) topology(edges []TopologyEdge) *Topology {
+	topology := &Topology{
+		Root:  g.root,
+		Nodes: map[string]*TopologyNode{},
+		Edges: []TopologyEdge{},
+	}
+	for host, node := range g.nodes {
+		topology.Nodes[host] = &TopologyNode{
+			PrometheusHost:   host,
+			AgentHost:        node.AgentHost,
+			AgentStatus:      node.AgentStatus,
+			PrometheusStatus: node.PrometheusStatus,
+			LastUpdated:      node.LastUpdated,
+			LastSeen:         node.LastSeen,
+			Stale:            node.Stale,
+		}
+	}
+	for _, edge := range edges {
+		for _, host := range []string{edge.Parent, edge.Child} {
+			if _, ok := topology.Nodes[host]; !ok {
+				topology.Nodes[host] = &TopologyNode{PrometheusHost: host}
+			}
+		}
+		topology.Nodes[edge.Parent].Children = append(topology.Nodes[edge.Parent].Children, edge.Child)
+		topology.Nodes[edge.Child].Parents = append(topology.Nodes[edge.Child].Parents, edge.Parent)
+		topology.Edges = append(topology.Edges, TopologyEdge{
+			Parent:     edge.Parent,
+			Child:      edge.Child,
+			Federation: cloneFederation(edge.Federation),
+		})
+	}
+	for _, node := range topology.Nodes {
+		sort.Strings(node.Parents)
+		sort.Strings(node.Children)
+	}
+	return topology
+}
+
+// Topology return the topology of graph, the result
+// is a snapshot and changes on it are not written back
+func (g *Graph) Topology() *Topology {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return g.topology(g.edges)
+}
@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	globalHosts   = []string{"global-prometheus-1:9090", "global-prometheus-2:9090"}
+	regionalHosts = []string{"regional-prometheus-1:9090", "regional-prometheus-2:9090"}
+)
+
+// newHATree return a tree which two global prometheus
+// federate the same regional prometheus servers
+func newHATree() *PrometheusNode {
+	nodeRoot, _ := NewPrometheusNode(rootHost)
+	nodeRoot.Children = NewPrometheusNodeList(globalHosts)
+	for _, global := range nodeRoot.Children {
+		global.Children = NewPrometheusNodeList(regionalHosts)
+		global.Children[0].Federation = &FederationEdge{JobName: global.PrometheusHost}
+	}
+	return nodeRoot
+}
+
+func TestGraphUpdateSharedNode(t *testing.T) {
+	graph := NewGraph(newHATree())
+	assert.True(t, graph.SearchAndUpdateAgentStatus(regionalHosts[0], true, true))
+	assert.True(t, graph.SearchAndUpdatePrometheusStatus(regionalHosts[0], true, true))
+	assert.False(t, graph.SearchAndUpdateAgentStatus(regionalHosts[0], false, true))
+	for _, global := range graph.Snapshot().Children {
+		assert.True(t, global.Children[0].AgentStatus)
+		assert.True(t, global.Children[0].PrometheusStatus)
+	}
+	assert.Equal(t, 5, len(graph.Topology().Nodes))
+
+	regional, _ := NewPrometheusNode(regionalHosts[0])
+	regional.Children = NewPrometheusNodeList(fedsChild11)
+	assert.Nil(t, graph.InsertOrUpdate(regional, false))
+	nodeRoot := graph.Snapshot()
+	assert.Equal(t, len(globalHosts), len(nodeRoot.Children))
+	for _, global := range nodeRoot.Children {
+		assert.Equal(t, len(fedsChild11), len(global.Children[0].Children))
+	}
+	// the children of shared node are stored once
+	assert.Equal(t, 5+len(fedsChild11), len(graph.Topology().Nodes))
+	assert.True(t, graph.SearchAndUpdateAgentStatus(fedsChild11[0], true, true))
+	for _, global := range graph.Snapshot().Children {
+		if global.Children[0].Children[0].AgentStatus != true {
+			t.Fatalf("Expect status of %s updated under %s, but got false", fedsChild11[0], global.PrometheusHost)
+		}
+	}
+	// the federation edge is kept for each parent
+	nodeRoot = graph.Snapshot()
+	assert.Equal(t, globalHosts[0], nodeRoot.Children[0].Children[0].Federation.JobName)
+	assert.Equal(t, globalHosts[1], nodeRoot.Children[1].Children[0].Federation.JobName)
+
+	assert.True(t, graph.DeleteNodeByHost(regionalHosts[0]))
+	assert.False(t, graph.Search(regionalHosts[0], true))
+	assert.False(t, graph.Search(fedsChild11[0], true))
+	assert.True(t, graph.Search(regionalHosts[1], true))
+	assert.False(t, graph.DeleteNodeByHost(regionalHosts[0]))
+}
+
+func TestNewTopology(t *testing.T) {
+	now := time.Now()
+	nodeRoot := newHATree()
+	nodeRoot.Children[0].Children[0].LastSeen = now.Add(-time.Minute)
+	nodeRoot.Children[1].Children[0].LastSeen = now
+	nodeRoot.Children[1].Children[0].AgentStatus = true
+	nodeRoot.Children[1].Children[0].AgentHost = "regional-agent-1:19090"
+
+	topology := NewTopology(nodeRoot)
+	assert.Equal(t, rootHost, topology.Root)
+	assert.Equal(t, 5, len(topology.Nodes))
+	assert.Equal(t, 6, len(topology.Edges))
+
+	regional := topology.Nodes[regionalHosts[0]]
+	assert.Equal(t, globalHosts, regional.Parents)
+	assert.True(t, regional.AgentStatus)
+	assert.Equal(t, now, regional.LastSeen)
+	assert.Equal(t, "regional-agent-1:19090", regional.AgentHost)
+	assert.Equal(t, regionalHosts, topology.Nodes[globalHosts[0]].Children)
+	assert.Equal(t, []string{rootHost}, topology.Nodes[globalHosts[1]].Parents)
+
+	jobs := []string{}
+	for _, edge := range topology.Edges {
+		if edge.Child == regionalHosts[0] {
+			jobs = append(jobs, edge.Federation.JobName)
+		}
+	}
+	assert.Equal(t, globalHosts, jobs)
+}
+
+func TestGetGraphWithView(t *testing.T) {
+	handler := http.HandlerFunc(GetGraph(NewGraph(newHATree())))
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/graph?view=dag", nil)
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var topology Topology
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &topology))
+	assert.Equal(t, globalHosts, topology.Nodes[regionalHosts[1]].Parents)
+
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/graph?view=tree", nil)
+	handler.ServeHTTP(recorder, req)
+	var node PrometheusNode
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &node))
+	assert.Equal(t, regionalHosts[0], node.Children[1].Children[0].PrometheusHost)
+
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/graph?view=unknown", nil)
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// FetchGraph get the graph from agent's /graph api
+func FetchGraph(agent string, timeout time.Duration) (*PrometheusNode, error) {
+	if agent == "" {
+		return nil, errors.New("agent host is empty")
+	}
+	url := agent + "/graph"
+	if !strings.HasPrefix(url, "http://") {
+		url = "http://" + url
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch graph from %s return status code %d", agent, resp.StatusCode)
+	}
+	var node PrometheusNode
+	if err := json.NewDecoder(resp.Body).Decode(&node); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// Discover fetch the graph of each child's agent and merge it into
+// current tree, then crawl the new children level by level until
+// maxDepth reached. Every fetch request use its own timeout, and
+// hosts already in the path from root will be cut to avoid cycles.
+// The fetched nodes without agent host are resolved by resolver
+func (pn *PrometheusNode) Discover(maxDepth int, timeout time.Duration, resolver *AgentResolver) {
+	pn.discover(maxDepth, timeout, resolver, map[string]bool{pn.PrometheusHost: true})
+}
+
+func (pn *PrometheusNode) discover(depth int, timeout time.Duration, resolver *AgentResolver, path map[string]bool) {
+	if depth <= 0 || len(pn.Children) == 0 {
+		return
+	}
+	for _, node := range fetchChildren(pn.Children, timeout, resolver, path) {
+		if err := pn.InsertOrUpdate(node, true); err != nil {
+			log.Warnf("Merge graph of %s fail: %s", node.PrometheusHost, err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, child := range pn.Children {
+		if path[child.PrometheusHost] == true {
+			continue
+		}
+		wg.Add(1)
+		go func(child *PrometheusNode) {
+			defer wg.Done()
+			childPath := copyPath(path)
+			childPath[child.PrometheusHost] = true
+			child.discover(depth-1, timeout, resolver, childPath)
+		}(child)
+	}
+	wg.Wait()
+}
+
+// fetchChildren fetch the graph of each child's agent concurrently and
+// return the ones fetched successfully, with cycles to path cut
+func fetchChildren(children PrometheusNodeList, timeout time.Duration, resolver *AgentResolver, path map[string]bool) []*PrometheusNode {
+	fetched := make([]*PrometheusNode, len(children))
+	var wg sync.WaitGroup
+	for index, child := range children {
+		if path[child.PrometheusHost] == true {
+			continue
+		}
+		wg.Add(1)
+		go func(index int, child *PrometheusNode) {
+			defer wg.Done()
+			node, err := FetchGraph(child.GetAgentHost(), timeout)
+			if err != nil {
+				log.Debugf("Discover graph from %s fail: %s", child.GetAgentHost(), err)
+				return
+			}
+			if node.PrometheusHost != child.PrometheusHost {
+				log.Warnf("Agent %s report host %s, expect %s", child.GetAgentHost(), node.PrometheusHost, child.PrometheusHost)
+				return
+			}
+			resolver.ResolveTree(node)
+			fetched[index] = node
+		}(index, child)
+	}
+	wg.Wait()
+
+	nodes := []*PrometheusNode{}
+	for _, node := range fetched {
+		if node == nil {
+			continue
+		}
+		childPath := copyPath(path)
+		childPath[node.PrometheusHost] = true
+		node.cutCycles(childPath)
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// cutCycles remove children which already exist in the path
+func (pn *PrometheusNode) cutCycles(path map[string]bool) {
+	var children PrometheusNodeList
+	for _, child := range pn.Children {
+		if path[child.PrometheusHost] == true {
+			log.Warnf("Cycle found: %s federate %s which is its ancestor", pn.PrometheusHost, child.PrometheusHost)
+			continue
+		}
+		childPath := copyPath(path)
+		childPath[child.PrometheusHost] = true
+		child.cutCycles(childPath)
+		children = append(children, child)
+	}
+	pn.Children = children
+}
+
+func copyPath(path map[string]bool) map[string]bool {
+	newPath := make(map[string]bool, len(path)+1)
+	for host := range path {
+		newPath[host] = true
+	}
+	return newPath
+}
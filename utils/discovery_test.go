@@ -0,0 +1,95 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newAgentServer(node *PrometheusNode) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(GetGraph(NewGraph(node))))
+}
+
+func TestFetchGraph(t *testing.T) {
+	node, _ := NewPrometheusNode(fedsRoot[0])
+	node.Children = NewPrometheusNodeList(fedsChild11)
+	server := newAgentServer(node)
+	defer server.Close()
+
+	fetched, err := FetchGraph(server.URL, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, fedsRoot[0], fetched.PrometheusHost)
+	assert.Equal(t, len(fedsChild11), len(fetched.Children))
+
+	_, err = FetchGraph("127.0.0.1:1", time.Second)
+	assert.NotNil(t, err)
+	_, err = FetchGraph("", time.Second)
+	assert.NotNil(t, err)
+}
+
+func TestPrometheusNodeDiscover(t *testing.T) {
+	// source-prometheus-11 federate source-prometheus-111 and its ancestor
+	node11, _ := NewPrometheusNode(fedsChild11[0])
+	node11.Children = NewPrometheusNodeList([]string{fedsChild111[0], rootHost})
+	agent11 := newAgentServer(node11)
+	defer agent11.Close()
+
+	node1, _ := NewPrometheusNode(fedsRoot[0])
+	node1.Children = NewPrometheusNodeList([]string{fedsChild11[0]})
+	node1.Children[0].AgentHost = strings.TrimPrefix(agent11.URL, "http://")
+	agent1 := newAgentServer(node1)
+	defer agent1.Close()
+
+	newRoot := func() *PrometheusNode {
+		nodeRoot, _ := NewPrometheusNode(rootHost)
+		nodeRoot.Children = NewPrometheusNodeList(fedsRoot[:2])
+		nodeRoot.Children[0].AgentHost = strings.TrimPrefix(agent1.URL, "http://")
+		nodeRoot.Children[1].AgentHost = "127.0.0.1:1"
+		return nodeRoot
+	}
+
+	nodeRoot := newRoot()
+	nodeRoot.Discover(1, time.Second, nil)
+	assert.True(t, nodeRoot.Search(fedsChild11[0], true))
+	assert.False(t, nodeRoot.Search(fedsChild111[0], true))
+
+	nodeRoot = newRoot()
+	nodeRoot.Discover(5, time.Second, nil)
+	assert.True(t, nodeRoot.Search(fedsChild111[0], true))
+	node := nodeRoot.Children[0].Children[0]
+	assert.Equal(t, fedsChild11[0], node.PrometheusHost)
+	assert.Equal(t, 1, len(node.Children))
+	assert.False(t, node.Search(rootHost, true))
+}
+
+func TestGraphDiscoverKeepConcurrentUpdates(t *testing.T) {
+	nodeRoot, _ := NewPrometheusNode(rootHost)
+	nodeRoot.Children = NewPrometheusNodeList(fedsRoot[:2])
+	nodeRoot.Children[1].AgentHost = "127.0.0.1:1"
+	graph := NewGraph(nodeRoot)
+
+	// the push of source-prometheus-2 lands while agent of
+	// source-prometheus-1 is being crawled
+	node1, _ := NewPrometheusNode(fedsRoot[0])
+	node1.Children = NewPrometheusNodeList(fedsChild11)
+	agent1 := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pushed, _ := NewPrometheusNode(fedsRoot[1])
+		pushed.Children = NewPrometheusNodeList(fedsChild21)
+		graph.InsertOrUpdate(pushed, true)
+		GetGraph(NewGraph(node1))(w, r)
+	}))
+	defer agent1.Close()
+	graph.Update(func(root *PrometheusNode) {
+		root.Children[0].AgentHost = strings.TrimPrefix(agent1.URL, "http://")
+	})
+
+	graph.Discover(1, time.Second)
+	snapshot := graph.Snapshot()
+	assert.Equal(t, len(fedsChild11), len(snapshot.Children[0].Children))
+	assert.False(t, snapshot.Children[0].LastUpdated.IsZero())
+	assert.Equal(t, len(fedsChild21), len(snapshot.Children[1].Children))
+}
@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// node states used for coloring the exported graph
+const (
+	stateOK        = "ok"
+	stateAgentDown = "agent_down"
+	stateDown      = "down"
+	stateStale     = "stale"
+)
+
+var dotColors = map[string]string{
+	stateOK:        "palegreen",
+	stateAgentDown: "orange",
+	stateDown:      "lightcoral",
+	stateStale:     "lightgray",
+}
+
+var mermaidStyles = map[string]string{
+	stateOK:        "fill:#98fb98,stroke:#2e8b57",
+	stateAgentDown: "fill:#ffa500,stroke:#cc8400",
+	stateDown:      "fill:#f08080,stroke:#b22222",
+	stateStale:     "fill:#d3d3d3,stroke:#808080",
+}
+
+// state return the state of node for coloring, stale node is not
+// trusted so it is checked first
+func (node *TopologyNode) state() string {
+	switch {
+	case node.Stale:
+		return stateStale
+	case node.PrometheusStatus && node.AgentStatus:
+		return stateOK
+	case node.PrometheusStatus:
+		return stateAgentDown
+	default:
+		return stateDown
+	}
+}
+
+// sortedHosts return the hosts of topology in order
+func (t *Topology) sortedHosts() []string {
+	hosts := []string{}
+	for host := range t.Nodes {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// jobName return the federate job name of edge or empty if unknown
+func (edge TopologyEdge) jobName() string {
+	if edge.Federation == nil {
+		return ""
+	}
+	return edge.Federation.JobName
+}
+
+// DOT render the topology in graphviz dot language, edges are labelled
+// by the federate job name of parent, nodes are colored by status
+// of agent and prometheus when withStatus is true
+func (t *Topology) DOT(withStatus bool) string {
+	var b strings.Builder
+	b.WriteString("digraph hercules {\n")
+	b.WriteString("  node [shape=box];\n")
+	for _, host := range t.sortedHosts() {
+		if withStatus {
+			fmt.Fprintf(&b, "  %q [style=filled, fillcolor=%s];\n", host, dotColors[t.Nodes[host].state()])
+		} else {
+			fmt.Fprintf(&b, "  %q;\n", host)
+		}
+	}
+	for _, edge := range t.Edges {
+		if job := edge.jobName(); job != "" {
+			fmt.Fprintf(&b, "  %q -> %q [label=%q];\n", edge.Parent, edge.Child, job)
+		} else {
+			fmt.Fprintf(&b, "  %q -> %q;\n", edge.Parent, edge.Child)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid render the topology as mermaid flowchart, node ids are
+// generated since host is not a valid mermaid id
+func (t *Topology) Mermaid(withStatus bool) string {
+	var b strings.Builder
+	b.WriteString("graph TD\n")
+	ids := map[string]string{}
+	hosts := t.sortedHosts()
+	for index, host := range hosts {
+		ids[host] = fmt.Sprintf("n%d", index)
+		fmt.Fprintf(&b, "  %s[\"%s\"]\n", ids[host], mermaidEscape(host))
+	}
+	for _, edge := range t.Edges {
+		if job := edge.jobName(); job != "" {
+			fmt.Fprintf(&b, "  %s -->|\"%s\"| %s\n", ids[edge.Parent], mermaidEscape(job), ids[edge.Child])
+		} else {
+			fmt.Fprintf(&b, "  %s --> %s\n", ids[edge.Parent], ids[edge.Child])
+		}
+	}
+	if withStatus {
+		states := map[string][]string{}
+		for _, host := range hosts {
+			state := t.Nodes[host].state()
+			states[state] = append(states[state], ids[host])
+		}
+		for _, state := range []string{stateOK, stateAgentDown, stateDown, stateStale} {
+			if len(states[state]) == 0 {
+				continue
+			}
+			fmt.Fprintf(&b, "  classDef %s %s\n", state, mermaidStyles[state])
+			fmt.Fprintf(&b, "  class %s %s\n", strings.Join(states[state], ","), state)
+		}
+	}
+	return b.String()
+}
+
+func mermaidEscape(text string) string {
+	return strings.Replace(text, "\"", "#quot;", -1)
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopologyDOT(t *testing.T) {
+	configs := loadLintConfigs(t, "testdata/lint/global.yml", "testdata/lint/regional-1.yml")
+	expect := `digraph hercules {
+  node [shape=box];
+  "global-prometheus:9090";
+  "leaf-prometheus-1:9090";
+  "regional-prometheus-1:9090";
+  "regional-prometheus-2:9090";
+  "global-prometheus:9090" -> "regional-prometheus-1:9090" [label="federate"];
+  "global-prometheus:9090" -> "regional-prometheus-2:9090" [label="federate"];
+  "regional-prometheus-1:9090" -> "leaf-prometheus-1:9090" [label="federate"];
+}
+`
+	if dot := NewTopologyFromConfigs(configs).DOT(false); dot != expect {
+		t.Fatalf("Expect dot %s, but got %s", expect, dot)
+	}
+}
+
+func newStatusTree() *PrometheusNode {
+	root := &PrometheusNode{PrometheusHost: "global:9090", AgentStatus: true, PrometheusStatus: true}
+	root.Children = PrometheusNodeList{
+		{PrometheusHost: "regional-1:9090", PrometheusStatus: true, Federation: &FederationEdge{JobName: "federate"}},
+		{PrometheusHost: "regional-2:9090", AgentStatus: true, Federation: &FederationEdge{JobName: "federate"}},
+		{PrometheusHost: "regional-3:9090", AgentStatus: true, PrometheusStatus: true, Stale: true},
+	}
+	return root
+}
+
+func TestTopologyDOTWithStatus(t *testing.T) {
+	dot := NewTopology(newStatusTree()).DOT(true)
+	for _, line := range []string{
+		`"global:9090" [style=filled, fillcolor=palegreen];`,
+		`"regional-1:9090" [style=filled, fillcolor=orange];`,
+		`"regional-2:9090" [style=filled, fillcolor=lightcoral];`,
+		`"regional-3:9090" [style=filled, fillcolor=lightgray];`,
+		`"global:9090" -> "regional-3:9090";`,
+	} {
+		if !strings.Contains(dot, line) {
+			t.Fatalf("Expect dot contains %s, but got %s", line, dot)
+		}
+	}
+}
+
+func TestTopologyMermaid(t *testing.T) {
+	expect := `graph TD
+  n0["global:9090"]
+  n1["regional-1:9090"]
+  n2["regional-2:9090"]
+  n3["regional-3:9090"]
+  n0 -->|"federate"| n1
+  n0 -->|"federate"| n2
+  n0 --> n3
+  classDef ok fill:#98fb98,stroke:#2e8b57
+  class n0 ok
+  classDef agent_down fill:#ffa500,stroke:#cc8400
+  class n1 agent_down
+  classDef down fill:#f08080,stroke:#b22222
+  class n2 down
+  classDef stale fill:#d3d3d3,stroke:#808080
+  class n3 stale
+`
+	if mermaid := NewTopology(newStatusTree()).Mermaid(true); mermaid != expect {
+		t.Fatalf("Expect mermaid %s, but got %s", expect, mermaid)
+	}
+}
+
+func TestGetGraphWithFormat(t *testing.T) {
+	handler := http.HandlerFunc(GetGraph(NewGraph(newStatusTree())))
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/graph?format=dot", nil)
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "text/vnd.graphviz; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.True(t, strings.HasPrefix(recorder.Body.String(), "digraph hercules {"))
+
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/graph?format=mermaid", nil)
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.True(t, strings.HasPrefix(recorder.Body.String(), "graph TD"))
+
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/graph?format=svg", nil)
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
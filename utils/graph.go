@@ -1,13 +1,23 @@
 package utils
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
 )
 
+// DefaultAgentPort is the port of agent when agent host is not set
+const DefaultAgentPort = 19090
+
 // PrometheusNode will include current node infomations
 type PrometheusNode struct {
 	Children         PrometheusNodeList `json:"children"`
@@ -15,6 +25,23 @@ type PrometheusNode struct {
 	PrometheusStatus bool               `json:"prometheus_status"`
 	PrometheusHost   string             `json:"prometheus_host"`
 	AgentHost        string             `json:"agent_host"`
+	Federation       *FederationEdge    `json:"federation,omitempty"`
+	LastUpdated      time.Time          `json:"last_updated"`
+	LastSeen         time.Time          `json:"last_seen"`
+	Stale            bool               `json:"stale"`
+	Latency          time.Duration      `json:"latency"`
+}
+
+// FederationEdge is the federate job infomation between parent and
+// current node, taken from the scrape config of parent prometheus
+type FederationEdge struct {
+	JobName        string   `json:"job_name"`
+	ScrapeInterval string   `json:"scrape_interval"`
+	ScrapeTimeout  string   `json:"scrape_timeout"`
+	Match          []string `json:"match,omitempty"`
+	HonorLabels    bool     `json:"honor_labels"`
+	Scheme         string   `json:"scheme"`
+	MetricsPath    string   `json:"metrics_path"`
 }
 
 // NewPrometheusNode create a new node with children nodes
@@ -61,78 +88,112 @@ func (pn *PrometheusNode) Search(host string, recursive bool) bool {
 	return false
 }
 
-// SearchAndUpdateAgentStatus will find the matched nodes and update agent status
+// SearchAndUpdateAgentStatus will find the matched nodes and update agent status,
+// a node federated by many parents is updated in every place it appears
 func (pn *PrometheusNode) SearchAndUpdateAgentStatus(host string, recursive bool, status bool) bool {
+	found := false
 	for _, children := range pn.Children {
 		if children.PrometheusHost == host {
 			children.AgentStatus = status
-			return true
+			found = true
 		}
-		if recursive == true {
-			children.SearchAndUpdateAgentStatus(host, true, status)
+		if recursive == true && children.SearchAndUpdateAgentStatus(host, true, status) {
+			found = true
 		}
 	}
-	return false
+	return found
 }
 
-// SearchAndUpdatePrometheusStatus will find the matched nodes and update prometheus status
+// SearchAndUpdatePrometheusStatus will find the matched nodes and update prometheus status,
+// a node federated by many parents is updated in every place it appears
 func (pn *PrometheusNode) SearchAndUpdatePrometheusStatus(host string, recursive bool, status bool) bool {
+	found := false
 	for _, children := range pn.Children {
 		if children.PrometheusHost == host {
 			children.PrometheusStatus = status
-			return true
+			found = true
 		}
-		if recursive == true {
-			children.SearchAndUpdatePrometheusStatus(host, true, status)
+		if recursive == true && children.SearchAndUpdatePrometheusStatus(host, true, status) {
+			found = true
 		}
 	}
-	return false
+	return found
+}
+
+// searchAll call fn with every node matched the host in the tree
+func (pn *PrometheusNode) searchAll(host string, fn func(node *PrometheusNode)) bool {
+	found := false
+	for _, child := range pn.Children {
+		if child.PrometheusHost == host {
+			fn(child)
+			found = true
+		}
+		if child.searchAll(host, fn) {
+			found = true
+		}
+	}
+	return found
 }
 
 // InsertOrUpdate will insert new node if not exist or update exist
-// if search exist in its tree
-func (pn *PrometheusNode) InsertOrUpdate(newNode *PrometheusNode, search bool) {
+// if search exist in its tree, a node federated by many parents
+// is updated in every place it appears. Return error and keep the
+// tree unchanged if the new node would make a federation cycle
+func (pn *PrometheusNode) InsertOrUpdate(newNode *PrometheusNode, search bool) error {
+	if err := pn.checkInsertCycle(newNode, search); err != nil {
+		return err
+	}
 	// search only in first layer
 	// if not exist , then append on its children array
+	now := time.Now()
 	if search && pn.Search(newNode.PrometheusHost, false) == false {
+		newNode.touchInserted(now)
+		newNode.LastUpdated = now
 		pn.Children = append(pn.Children, newNode)
-		return
+		return nil
 	}
-	for _, child := range pn.Children {
-		if child.PrometheusHost == newNode.PrometheusHost {
-			child.Children = newNode.Children
-			return
+	pn.searchAll(newNode.PrometheusHost, func(node *PrometheusNode) {
+		// every place has its own copy to keep the tree structure
+		node.Children = newNode.Clone().Children
+		for _, child := range node.Children {
+			child.touchInserted(now)
 		}
-		child.InsertOrUpdate(newNode, false)
-	}
+		node.LastUpdated = now
+	})
+	return nil
 }
 
-// DeleteNodeByHost will delete from graph by host name
+// DeleteNodeByHost will delete from graph by host name,
+// every node matched the host is deleted
 func (pn *PrometheusNode) DeleteNodeByHost(host string) bool {
-	for index, children := range pn.Children {
-		if children.PrometheusHost == host {
-			pn.Children = append(pn.Children[:index], pn.Children[index+1:]...)
-			return true
+	deleted := false
+	var children PrometheusNodeList
+	for _, child := range pn.Children {
+		if child.PrometheusHost == host {
+			deleted = true
+			continue
 		}
-		deleteStatus := children.DeleteNodeByHost(host)
-		if deleteStatus == true {
-			return true
+		if child.DeleteNodeByHost(host) == true {
+			deleted = true
 		}
+		children = append(children, child)
 	}
-	return false
+	if len(children) != len(pn.Children) {
+		pn.Children = children
+	}
+	return deleted
 }
 
-// PrintNodesTree print out the struct of nodes
+// PrintNodesTree print out the struct of nodes, use RenderTree
+// for a box-drawing tree with more details
 func (pn *PrometheusNode) PrintNodesTree(prefix string, depth int, withStatus bool) string {
 	prefixWithDepth := strings.Repeat(prefix, depth)
 	status := ""
 	if withStatus == true {
-		if pn.AgentStatus == true {
-			status = "[agent=ok]"
-		} else {
-			status = "[agent=error]"
+		status = "[agent=" + statusText(pn.AgentStatus) + "][prometheus=" + statusText(pn.PrometheusStatus) + "]"
+		if pn.Stale == true {
+			status = status + "[stale]"
 		}
-	} else {
 	}
 	tree := "\n" + prefixWithDepth + pn.PrometheusHost + status
 	for _, child := range pn.Children {
@@ -141,25 +202,195 @@ func (pn *PrometheusNode) PrintNodesTree(prefix string, depth int, withStatus bo
 	return tree
 }
 
-// Ping will send request to each children and get back the response
-func (pn *PrometheusNode) Ping() {
-	// todo : update status only ping the first level of children
-	// check current prometheus host status
-	// MakeRequest(pn.PrometheusHost)
+// GetAgentHost return the agent address of current node, if agent host
+// is empty, use the same host as prometheus with the default agent port.
+// Nodes in graph get agent host from AgentResolver, so the fallback is
+// only for nodes built without it
+func (pn *PrometheusNode) GetAgentHost() string {
+	if pn.AgentHost != "" {
+		return pn.AgentHost
+	}
+	host, _, err := net.SplitHostPort(pn.PrometheusHost)
+	if err != nil {
+		host = pn.PrometheusHost
+	}
+	return net.JoinHostPort(host, strconv.Itoa(DefaultAgentPort))
+}
+
+// Scheme return the scheme parent scrapes the prometheus with,
+// http is used when the federation is unknown
+func (pn *PrometheusNode) Scheme() string {
+	if pn.Federation == nil || pn.Federation.Scheme == "" {
+		return "http"
+	}
+	return pn.Federation.Scheme
+}
+
+// CheckPrometheusHealth check the prometheus server is healthy and ready,
+// both checks share the timeout
+func CheckPrometheusHealth(host string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := checkHealth(ctx, host+"/-/healthy"); err != nil {
+		return err
+	}
+	return checkHealth(ctx, host+"/-/ready")
+}
+
+// CheckAgentHealth check the hercules agent is alive
+func CheckAgentHealth(host string, timeout time.Duration) error {
+	return CheckHealth(host+"/status", timeout)
+}
+
+type pingTarget struct {
+	host      string
+	scheme    string
+	agentHost string
+}
+
+type pingResult struct {
+	host    string
+	self    bool
+	agent   bool
+	status  bool
+	latency time.Duration
+}
+
+// pingTargets return the first level children need to be checked
+func (pn *PrometheusNode) pingTargets() []pingTarget {
+	targets := make([]pingTarget, 0, len(pn.Children))
+	for _, child := range pn.Children {
+		targets = append(targets, pingTarget{host: child.PrometheusHost, scheme: child.Scheme(), agentHost: child.GetAgentHost()})
+	}
+	return targets
+}
+
+// ping check the prometheus of self and each target's agent
+// and prometheus concurrently
+func ping(self string, targets []pingTarget, timeout time.Duration) []pingResult {
+	var wg sync.WaitGroup
+	resultsChan := make(chan pingResult, 2*len(targets)+1)
+	probe := func(result pingResult, target string, check func(string, time.Duration) error) {
+		defer wg.Done()
+		start := time.Now()
+		err := check(target, timeout)
+		if err != nil {
+			log.Debugf("Ping %s fail: %s", target, err)
+		} else {
+			result.latency = time.Since(start)
+		}
+		result.status = err == nil
+		resultsChan <- result
+	}
+	wg.Add(1)
+	go probe(pingResult{host: self, self: true}, self, CheckPrometheusHealth)
+	for _, target := range targets {
+		wg.Add(2)
+		go probe(pingResult{host: target.host, agent: true}, target.agentHost, CheckAgentHealth)
+		go probe(pingResult{host: target.host}, HostURL(target.scheme, target.host), CheckPrometheusHealth)
+	}
+	wg.Wait()
+	close(resultsChan)
+	results := []pingResult{}
+	for result := range resultsChan {
+		results = append(results, result)
+	}
+	return results
+}
+
+func (pn *PrometheusNode) applyPingResults(results []pingResult) {
+	now := time.Now()
+	// current agent is running, so always alive
+	pn.AgentStatus = true
+	pn.LastSeen = now
+	for _, result := range results {
+		if result.self == true {
+			pn.applyPingResult(result, now)
+			continue
+		}
+		pn.searchAll(result.host, func(node *PrometheusNode) {
+			node.applyPingResult(result, now)
+		})
+	}
+}
+
+// applyPingResult update the status of current node by one check
+func (pn *PrometheusNode) applyPingResult(result pingResult, now time.Time) {
+	if result.agent == true {
+		pn.AgentStatus = result.status
+	} else {
+		pn.PrometheusStatus = result.status
+		pn.Latency = result.latency
+	}
+	if result.status == true {
+		pn.LastSeen = now
+	}
+}
+
+// Ping will check current prometheus and each children's agent and prometheus
+// concurrently, every request has its own timeout. Only the first level of
+// children will be checked, deeper nodes are updated by their own agent
+func (pn *PrometheusNode) Ping(timeout time.Duration) {
+	pn.applyPingResults(ping(pn.PrometheusHost, pn.pingTargets(), timeout))
+}
+
+// Clone return a deep copy of node and its children
+func (pn *PrometheusNode) Clone() *PrometheusNode {
+	node := *pn
+	if pn.Federation != nil {
+		edge := *pn.Federation
+		edge.Match = append([]string(nil), pn.Federation.Match...)
+		node.Federation = &edge
+	}
+	node.Children = nil
+	for _, child := range pn.Children {
+		node.Children = append(node.Children, child.Clone())
+	}
+	return &node
 }
 
 // GetGraph will return a http handler function
-// which encode current node info to json response
-func GetGraph(pn *PrometheusNode) func(w http.ResponseWriter, r *http.Request) {
+// which encode current node info to json response,
+// use view=dag to get the topology keyed by host and
+// format=dot or format=mermaid to get the rendered graph
+func GetGraph(g *Graph) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
+		switch format := r.URL.Query().Get("format"); format {
+		case "", "json":
+		case "dot":
+			w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(g.Topology().DOT(true)))
+			return
+		case "mermaid":
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(g.Topology().Mermaid(true)))
+			return
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Unknown format %s", format)
+			return
+		}
+		var data interface{}
+		switch view := r.URL.Query().Get("view"); view {
+		case "", "tree":
+			data = g.Snapshot()
+		case "dag":
+			data = g.Topology()
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Unknown view %s", view)
+			return
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(pn)
+		json.NewEncoder(w).Encode(data)
 	}
 }
 
 // UpdateGraph will insert or update a node from post data
-func UpdateGraph(pn *PrometheusNode) func(w http.ResponseWriter, r *http.Request) {
+func UpdateGraph(g *Graph) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var node PrometheusNode
 		if err := json.NewDecoder(r.Body).Decode(&node); err != nil {
@@ -172,8 +403,13 @@ func UpdateGraph(pn *PrometheusNode) func(w http.ResponseWriter, r *http.Request
 			w.Write([]byte("Invalid request"))
 			return
 		}
-		pn.InsertOrUpdate(&node, true)
+		if err := g.InsertOrUpdate(&node, true); err != nil {
+			log.Warnf("Refuse graph update from %s: %s", r.RemoteAddr, err)
+			w.WriteHeader(http.StatusConflict)
+			w.Write([]byte(err.Error()))
+			return
+		}
 		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(pn)
+		json.NewEncoder(w).Encode(g.Snapshot())
 	}
 }
@@ -5,7 +5,9 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -166,25 +168,25 @@ source-prometheus:9090
 		t.Fatalf("Expect %s, but got %s", expect, nodeRoot.PrintNodesTree("————", 0, false))
 	}
 	expect = `
-source-prometheus:9090[agent=error]
-————source-prometheus-1:9090[agent=error]
-————source-prometheus-2:9090[agent=error]
-————————source-prometheus-21:9090[agent=error]
-————————source-prometheus-22:9090[agent=error]
-————————source-prometheus-23:9090[agent=error]
-————source-prometheus-3:9090[agent=error]`
+source-prometheus:9090[agent=error][prometheus=error]
+————source-prometheus-1:9090[agent=error][prometheus=error]
+————source-prometheus-2:9090[agent=error][prometheus=error]
+————————source-prometheus-21:9090[agent=error][prometheus=error]
+————————source-prometheus-22:9090[agent=error][prometheus=error]
+————————source-prometheus-23:9090[agent=error][prometheus=error]
+————source-prometheus-3:9090[agent=error][prometheus=error]`
 	if expect != nodeRoot.PrintNodesTree("————", 0, true) {
 		t.Fatalf("Expect %s, but got %s", expect, nodeRoot.PrintNodesTree("————", 0, true))
 	}
 	nodeRoot.SearchAndUpdateAgentStatus(childrenHosts[2], true, true)
 	expect = `
-source-prometheus:9090[agent=error]
-————source-prometheus-1:9090[agent=error]
-————source-prometheus-2:9090[ok]
-————————source-prometheus-21:9090[agent=error]
-————————source-prometheus-22:9090[agent=error]
-————————source-prometheus-23:9090[agent=error]
-————source-prometheus-3:9090[agent=error]`
+source-prometheus:9090[agent=error][prometheus=error]
+————source-prometheus-1:9090[agent=error][prometheus=error]
+————source-prometheus-2:9090[agent=ok][prometheus=error]
+————————source-prometheus-21:9090[agent=error][prometheus=error]
+————————source-prometheus-22:9090[agent=error][prometheus=error]
+————————source-prometheus-23:9090[agent=error][prometheus=error]
+————source-prometheus-3:9090[agent=error][prometheus=error]`
 	if expect != nodeRoot.PrintNodesTree("————", 0, true) {
 		t.Fatalf("Expect %s, but got %s", expect, nodeRoot.PrintNodesTree("————", 0, true))
 	}
@@ -218,7 +220,7 @@ func TestGetFederationHostsAndReturnGraphNode(t *testing.T) {
 	node.Children = children
 	req, err := http.NewRequest("GET", "/graph", nil)
 	assert.Nil(t, err)
-	handlerFunc := GetGraph(node)
+	handlerFunc := GetGraph(NewGraph(node))
 	recorder := httptest.NewRecorder()
 	handler := http.HandlerFunc(handlerFunc)
 	handler.ServeHTTP(recorder, req)
@@ -243,7 +245,7 @@ func TestUpdateGraphNodeWithBadRequest(t *testing.T) {
 	node, _ := NewPrometheusNode(currentNode)
 	children := NewPrometheusNodeList(feds)
 	node.Children = children
-	handlerFunc := UpdateGraph(node)
+	handlerFunc := UpdateGraph(NewGraph(node))
 	recorder := httptest.NewRecorder()
 	handler := http.HandlerFunc(handlerFunc)
 	PostDataList := []string{
@@ -283,7 +285,7 @@ func TestGetFederationHostsAndUpdateGraphNode(t *testing.T) {
 		}`)))
 
 	assert.Nil(t, err)
-	handlerFunc := UpdateGraph(node)
+	handlerFunc := UpdateGraph(NewGraph(node))
 	recorder := httptest.NewRecorder()
 	handler := http.HandlerFunc(handlerFunc)
 	handler.ServeHTTP(recorder, req)
@@ -302,3 +304,75 @@ func TestGetFederationHostsAndUpdateGraphNode(t *testing.T) {
 	assert.Equal(t, pnl[0].Children[0].PrometheusHost, "source-prometheus-11:9090")
 	assert.True(t, pnl[0].Children[0].AgentStatus)
 }
+
+func newPrometheusServer(healthy bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if healthy == false {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+}
+
+func TestPrometheusNodePing(t *testing.T) {
+	rootPrometheus := newPrometheusServer(true)
+	defer rootPrometheus.Close()
+	childPrometheus := newPrometheusServer(false)
+	defer childPrometheus.Close()
+	childAgent := newPrometheusServer(true)
+	defer childAgent.Close()
+
+	nodeRoot, _ := NewPrometheusNode(strings.TrimPrefix(rootPrometheus.URL, "http://"))
+	nodeRoot.Children = NewPrometheusNodeList([]string{
+		strings.TrimPrefix(childPrometheus.URL, "http://"),
+		"127.0.0.1:1",
+	})
+	nodeRoot.Children[0].AgentHost = strings.TrimPrefix(childAgent.URL, "http://")
+	nodeRoot.Children[1].AgentHost = "127.0.0.1:1"
+
+	nodeRoot.Ping(time.Second)
+	assert.True(t, nodeRoot.AgentStatus)
+	assert.True(t, nodeRoot.PrometheusStatus)
+	assert.True(t, nodeRoot.Latency > 0)
+	assert.True(t, nodeRoot.Children[0].AgentStatus)
+	assert.False(t, nodeRoot.Children[0].PrometheusStatus)
+	assert.Equal(t, time.Duration(0), nodeRoot.Children[0].Latency)
+	assert.False(t, nodeRoot.Children[1].AgentStatus)
+	assert.False(t, nodeRoot.Children[1].PrometheusStatus)
+}
+
+func TestPrometheusNodePingTargetsScheme(t *testing.T) {
+	nodeRoot, _ := NewPrometheusNode(rootHost)
+	nodeRoot.Children = NewPrometheusNodeList(fedsRoot[:2])
+	nodeRoot.Children[0].Federation = &FederationEdge{Scheme: "https"}
+	targets := nodeRoot.pingTargets()
+	assert.Equal(t, "https", targets[0].scheme)
+	assert.Equal(t, "http", targets[1].scheme)
+}
+
+func TestCheckPrometheusHealthTimeout(t *testing.T) {
+	// each check is in time, but both together are not
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(300 * time.Millisecond):
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	start := time.Now()
+	if err := CheckPrometheusHealth(strings.TrimPrefix(server.URL, "http://"), 500*time.Millisecond); err == nil {
+		t.Fatal("Expect health check timeout, but got nil")
+	}
+	if elapsed := time.Since(start); elapsed > 550*time.Millisecond {
+		t.Fatalf("Expect health check stop in timeout, but got %s", elapsed)
+	}
+}
+
+func TestPrometheusNodeGetAgentHost(t *testing.T) {
+	node, _ := NewPrometheusNode("source-prometheus-1:9090")
+	assert.Equal(t, "source-prometheus-1:19090", node.GetAgentHost())
+	node.AgentHost = "agent-1:8080"
+	assert.Equal(t, "agent-1:8080", node.GetAgentHost())
+}
@@ -0,0 +1,225 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Severity of lint problems
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+)
+
+// LintProblem is a problem found in federation configs
+type LintProblem struct {
+	Severity string `json:"severity"`
+	Check    string `json:"check"`
+	Host     string `json:"host"`
+	Message  string `json:"message"`
+}
+
+func (p LintProblem) String() string {
+	return fmt.Sprintf("[%s] %s %s: %s", p.Severity, p.Check, p.Host, p.Message)
+}
+
+// broadMatcherRE match the label matcher which select everything
+var broadMatcherRE = regexp.MustCompile(`^\s*[a-zA-Z_][a-zA-Z0-9_]*\s*(=~\s*"\.[*+]"|!=\s*"")\s*$`)
+
+// IsBroadSelector return true if the federate match[] selector
+// select all series, like {__name__=~".+"} or {job=~".*"}
+func IsBroadSelector(selector string) bool {
+	selector = strings.TrimSpace(selector)
+	if !strings.HasPrefix(selector, "{") || !strings.HasSuffix(selector, "}") {
+		return false
+	}
+	for _, matcher := range strings.Split(selector[1:len(selector)-1], ",") {
+		if strings.TrimSpace(matcher) != "" && !broadMatcherRE.MatchString(matcher) {
+			return false
+		}
+	}
+	return true
+}
+
+// Lint check the loaded offline configs and return the problems found,
+// the reachability is checked from root, which can be empty if configs
+// have only one root. Return error if root is not in the configs
+func Lint(configs []*OfflineConfig, root string) ([]LintProblem, error) {
+	topology := NewTopologyFromConfigs(configs)
+	if root == "" {
+		root = topology.Root
+	} else if _, ok := topology.Nodes[root]; !ok {
+		return nil, fmt.Errorf("prometheus host %s not found", root)
+	}
+	configsByHost := map[string]*OfflineConfig{}
+	for _, c := range configs {
+		configsByHost[c.Host] = c
+	}
+	problems := []LintProblem{}
+	problems = append(problems, lintCycles(topology)...)
+	problems = append(problems, lintDuplicates(configs, topology)...)
+	problems = append(problems, lintRoots(topology, root)...)
+	problems = append(problems, lintUnreachable(topology, root)...)
+	for _, c := range configs {
+		problems = append(problems, lintTargets(c, configsByHost)...)
+	}
+	return problems, nil
+}
+
+func lintCycles(topology *Topology) []LintProblem {
+	problems := []LintProblem{}
+	for _, cycle := range topology.FindCycles() {
+		problems = append(problems, LintProblem{
+			Severity: LintError,
+			Check:    "cycle",
+			Host:     cycle[0],
+			Message:  "federation cycle " + strings.Join(cycle, " -> "),
+		})
+	}
+	return problems
+}
+
+func lintDuplicates(configs []*OfflineConfig, topology *Topology) []LintProblem {
+	problems := []LintProblem{}
+	for _, c := range configs {
+		jobs := map[string][]string{}
+		hosts := []string{}
+		for _, target := range c.Targets {
+			if _, ok := jobs[target.PrometheusHost]; !ok {
+				hosts = append(hosts, target.PrometheusHost)
+			}
+			jobs[target.PrometheusHost] = append(jobs[target.PrometheusHost], target.JobName)
+		}
+		for _, host := range hosts {
+			if len(jobs[host]) > 1 {
+				problems = append(problems, LintProblem{
+					Severity: LintError,
+					Check:    "duplicate",
+					Host:     c.Host,
+					Message:  fmt.Sprintf("%s federated %d times by jobs %s", host, len(jobs[host]), strings.Join(jobs[host], ", ")),
+				})
+			}
+		}
+	}
+	hosts := []string{}
+	for host, node := range topology.Nodes {
+		if len(node.Parents) > 1 {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		problems = append(problems, LintProblem{
+			Severity: LintWarning,
+			Check:    "duplicate",
+			Host:     host,
+			Message:  "federated by multiple parents " + strings.Join(topology.Nodes[host].Parents, ", "),
+		})
+	}
+	return problems
+}
+
+// lintRoots report the hosts not federated by any parent except root,
+// without root every one of many roots is reported
+func lintRoots(topology *Topology, root string) []LintProblem {
+	roots := topology.Roots()
+	problems := []LintProblem{}
+	for _, host := range roots {
+		message := fmt.Sprintf("not federated by any parent and not the root %s", root)
+		if root == "" {
+			message = fmt.Sprintf("one of %d roots %s, choose the root with -root", len(roots), strings.Join(roots, ", "))
+		} else if host == root {
+			continue
+		}
+		problems = append(problems, LintProblem{
+			Severity: LintWarning,
+			Check:    "root",
+			Host:     host,
+			Message:  message,
+		})
+	}
+	return problems
+}
+
+// lintUnreachable report the hosts and leaves can't be reached from root,
+// extra roots are reported by lintRoots
+func lintUnreachable(topology *Topology, root string) []LintProblem {
+	problems := []LintProblem{}
+	if root == "" {
+		return problems
+	}
+	children := map[string][]string{}
+	for _, edge := range topology.Edges {
+		children[edge.Parent] = append(children[edge.Parent], edge.Child)
+	}
+	visited := map[string]bool{}
+	queue := []string{root}
+	for len(queue) > 0 {
+		host := queue[0]
+		queue = queue[1:]
+		if visited[host] == true {
+			continue
+		}
+		visited[host] = true
+		queue = append(queue, children[host]...)
+	}
+	hosts := []string{}
+	for host, node := range topology.Nodes {
+		if visited[host] == false && len(node.Parents) > 0 {
+			hosts = append(hosts, host)
+		}
+	}
+	sort.Strings(hosts)
+	for _, host := range hosts {
+		message := "not reachable from root " + root
+		if len(children[host]) == 0 {
+			message = "leaf not reachable from root " + root
+		}
+		problems = append(problems, LintProblem{
+			Severity: LintWarning,
+			Check:    "unreachable",
+			Host:     host,
+			Message:  message,
+		})
+	}
+	return problems
+}
+
+func lintTargets(c *OfflineConfig, configsByHost map[string]*OfflineConfig) []LintProblem {
+	problems := []LintProblem{}
+	add := func(severity, check, format string, args ...interface{}) {
+		problems = append(problems, LintProblem{
+			Severity: severity,
+			Check:    check,
+			Host:     c.Host,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+	for _, target := range c.Targets {
+		if target.HonorLabels == false {
+			add(LintWarning, "honor-labels", "job %s federate %s without honor_labels: true", target.JobName, target.PrometheusHost)
+		}
+		match := target.Params["match[]"]
+		if len(match) == 0 {
+			add(LintError, "match", "job %s federate %s without match[] selectors", target.JobName, target.PrometheusHost)
+		}
+		for _, selector := range match {
+			if IsBroadSelector(selector) {
+				add(LintWarning, "match", "job %s federate %s with broad selector %s", target.JobName, target.PrometheusHost, selector)
+			}
+		}
+		child, ok := configsByHost[target.PrometheusHost]
+		if !ok {
+			continue
+		}
+		evaluation := child.Config.GlobalConfig.EvaluationInterval
+		if time.Duration(target.ScrapeInterval) < time.Duration(evaluation) {
+			add(LintWarning, "interval", "job %s scrape %s every %s, shorter than its evaluation_interval %s",
+				target.JobName, target.PrometheusHost, target.ScrapeInterval, evaluation)
+		}
+	}
+	return problems
+}
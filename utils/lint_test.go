@@ -0,0 +1,92 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsBroadSelector(t *testing.T) {
+	assert.True(t, IsBroadSelector(`{__name__=~".+"}`))
+	assert.True(t, IsBroadSelector(`{ job =~ ".*" }`))
+	assert.True(t, IsBroadSelector(`{__name__=~".+", job!=""}`))
+	assert.True(t, IsBroadSelector(`{}`))
+	assert.False(t, IsBroadSelector(`{job="prometheus"}`))
+	assert.False(t, IsBroadSelector(`{__name__=~"job:.*"}`))
+	assert.False(t, IsBroadSelector(`{__name__=~".+", job="node"}`))
+	assert.False(t, IsBroadSelector(`up`))
+}
+
+func TestLint(t *testing.T) {
+	configs := loadLintConfigs(t, "testdata/lint/global.yml", "testdata/lint/regional-1.yml", "testdata/lint/regional-2.yml")
+	problems, err := Lint(configs, "")
+	assert.Nil(t, err)
+	messages := []string{}
+	for _, problem := range problems {
+		messages = append(messages, problem.String())
+	}
+	assert.Equal(t, []string{
+		"[error] duplicate global-prometheus:9090: regional-prometheus-1:9090 federated 2 times by jobs federate, federate-all",
+		"[warning] duplicate leaf-prometheus-1:9090: federated by multiple parents regional-prometheus-1:9090, regional-prometheus-2:9090",
+		"[warning] interval global-prometheus:9090: job federate scrape regional-prometheus-1:9090 every 15s, shorter than its evaluation_interval 30s",
+		"[warning] honor-labels global-prometheus:9090: job federate-all federate regional-prometheus-1:9090 without honor_labels: true",
+		`[warning] match global-prometheus:9090: job federate-all federate regional-prometheus-1:9090 with broad selector {__name__=~".+"}`,
+		"[warning] interval global-prometheus:9090: job federate-all scrape regional-prometheus-1:9090 every 15s, shorter than its evaluation_interval 30s",
+		"[error] match regional-prometheus-2:9090: job federate federate leaf-prometheus-1:9090 without match[] selectors",
+	}, messages)
+}
+
+func TestLintCycle(t *testing.T) {
+	configs := []*OfflineConfig{
+		{Host: "cycle-prometheus-a:9090", Path: "testdata/lint/cycle-a.yml"},
+		{Host: "cycle-prometheus-b:9090", Path: "testdata/lint/cycle-b.yml"},
+	}
+	assert.Nil(t, LoadOfflineConfigs(configs))
+	problems, err := Lint(configs, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(problems))
+	assert.Equal(t, LintProblem{
+		Severity: LintError,
+		Check:    "cycle",
+		Host:     "cycle-prometheus-a:9090",
+		Message:  "federation cycle cycle-prometheus-a:9090 -> cycle-prometheus-b:9090 -> cycle-prometheus-a:9090",
+	}, problems[0])
+
+	problems, err = Lint(configs, "cycle-prometheus-a:9090")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(problems))
+}
+
+func TestLintRoot(t *testing.T) {
+	configs := loadLintConfigs(t, "testdata/lint/global.yml", "testdata/lint/regional-1.yml",
+		"testdata/lint/regional-2.yml", "island-prometheus:9090=testdata/lint/island.yml")
+	filter := func(problems []LintProblem) []string {
+		messages := []string{}
+		for _, problem := range problems {
+			if problem.Check == "root" || problem.Check == "unreachable" {
+				messages = append(messages, problem.String())
+			}
+		}
+		return messages
+	}
+	problems, err := Lint(configs, "")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"[warning] root global-prometheus:9090: one of 2 roots global-prometheus:9090, island-prometheus:9090, choose the root with -root",
+		"[warning] root island-prometheus:9090: one of 2 roots global-prometheus:9090, island-prometheus:9090, choose the root with -root",
+	}, filter(problems))
+
+	problems, err = Lint(configs, "global-prometheus:9090")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{
+		"[warning] root island-prometheus:9090: not federated by any parent and not the root global-prometheus:9090",
+		"[warning] unreachable island-leaf-prometheus:9090: leaf not reachable from root global-prometheus:9090",
+	}, filter(problems))
+
+	problems, err = Lint(configs, "regional-prometheus-1:9090")
+	assert.Nil(t, err)
+	assert.Contains(t, filter(problems), "[warning] unreachable regional-prometheus-2:9090: not reachable from root regional-prometheus-1:9090")
+
+	_, err = Lint(configs, "unknown-prometheus:9090")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,262 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/prometheus/config"
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// OfflineConfig is a prometheus config file and the host of
+// prometheus using it, used to build the graph without agents
+type OfflineConfig struct {
+	Host    string
+	Path    string
+	Config  *config.Config
+	Targets []FederationTarget
+}
+
+// LoadHostMapping read the yaml file which map config file path to
+// prometheus host, relative paths are based on the directory of
+// mapping file, the keys returned are absolute paths
+func LoadHostMapping(path string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	content := map[string]string{}
+	if err := yaml.Unmarshal(data, &content); err != nil {
+		return nil, err
+	}
+	mapping := map[string]string{}
+	for file, host := range content {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(filepath.Dir(path), file)
+		}
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		mapping[abs] = host
+	}
+	return mapping, nil
+}
+
+// ParseConfigArgs parse the args in format path or host=path, the host
+// of path is looked up from mapping when not given. A directory or glob
+// pattern is expanded to the yaml files in it, and files not in mapping
+// are skipped since they may be rule files
+func ParseConfigArgs(args []string, mapping map[string]string) ([]*OfflineConfig, error) {
+	configs := []*OfflineConfig{}
+	seen := map[string]bool{}
+	add := func(host, path string) error {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		if seen[abs] == true {
+			return nil
+		}
+		seen[abs] = true
+		if host == "" {
+			host = mapping[abs]
+		}
+		if host == "" {
+			return fmt.Errorf("unknown prometheus host of config %s", path)
+		}
+		configs = append(configs, &OfflineConfig{Host: host, Path: path})
+		return nil
+	}
+	for _, arg := range args {
+		host, path := "", arg
+		if index := strings.Index(arg, "="); index >= 0 {
+			host, path = arg[:index], arg[index+1:]
+		}
+		paths, expanded, err := expandConfigPath(path)
+		if err != nil {
+			return nil, err
+		}
+		if !expanded {
+			if err := add(host, path); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		for _, path := range paths {
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := mapping[abs]; !ok {
+				log.Debugf("Skip config %s without prometheus host", path)
+				continue
+			}
+			if err := add("", path); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return configs, nil
+}
+
+// expandConfigPath return the sorted yaml files when path is a directory
+// (searched recursively) or a glob pattern, expanded is false otherwise
+func expandConfigPath(path string) (paths []string, expanded bool, err error) {
+	if strings.ContainsAny(path, "*?[") {
+		matches, err := filepath.Glob(path)
+		if err != nil {
+			return nil, true, err
+		}
+		if len(matches) == 0 {
+			return nil, true, fmt.Errorf("no config file match %s", path)
+		}
+		sort.Strings(matches)
+		return matches, true, nil
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.IsDir() {
+		return nil, false, nil
+	}
+	err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if ext := filepath.Ext(file); !info.IsDir() && (ext == ".yml" || ext == ".yaml") {
+			paths = append(paths, file)
+		}
+		return nil
+	})
+	return paths, true, err
+}
+
+// LoadOfflineConfigs load the config files and their federation targets
+func LoadOfflineConfigs(configs []*OfflineConfig) error {
+	hosts := map[string]string{}
+	for _, c := range configs {
+		if path, ok := hosts[c.Host]; ok {
+			return fmt.Errorf("host %s used by both %s and %s", c.Host, path, c.Path)
+		}
+		hosts[c.Host] = c.Path
+		conf, err := config.LoadFile(c.Path)
+		if err != nil {
+			return err
+		}
+		c.Config = conf
+		c.Targets = GetFederationTargets(conf, filepath.Dir(c.Path))
+	}
+	return nil
+}
+
+// NewTopologyFromConfigs build the topology of offline configs, targets
+// without config are leaves. Root is set only when there is one host
+// not federated by others
+func NewTopologyFromConfigs(configs []*OfflineConfig) *Topology {
+	topology := &Topology{
+		Nodes: map[string]*TopologyNode{},
+		Edges: []TopologyEdge{},
+	}
+	addNode := func(host string) *TopologyNode {
+		if _, ok := topology.Nodes[host]; !ok {
+			topology.Nodes[host] = &TopologyNode{PrometheusHost: host}
+		}
+		return topology.Nodes[host]
+	}
+	edges := map[[2]string]bool{}
+	for _, c := range configs {
+		parent := addNode(c.Host)
+		for _, target := range c.Targets {
+			child := addNode(target.PrometheusHost)
+			if child.AgentHost == "" {
+				child.AgentHost = target.AgentHost
+			}
+			key := [2]string{c.Host, target.PrometheusHost}
+			if edges[key] == true {
+				continue
+			}
+			edges[key] = true
+			topology.Edges = append(topology.Edges, TopologyEdge{
+				Parent:     c.Host,
+				Child:      target.PrometheusHost,
+				Federation: target.Edge(),
+			})
+			parent.Children = append(parent.Children, target.PrometheusHost)
+			child.Parents = append(child.Parents, c.Host)
+		}
+	}
+	if roots := topology.Roots(); len(roots) == 1 {
+		topology.Root = roots[0]
+	}
+	return topology
+}
+
+// Roots return the sorted hosts which are not federated by others
+func (t *Topology) Roots() []string {
+	roots := []string{}
+	for host, node := range t.Nodes {
+		if len(node.Parents) == 0 {
+			roots = append(roots, host)
+		}
+	}
+	sort.Strings(roots)
+	return roots
+}
+
+// Tree build the node tree from host following the federation edges, a
+// host federated by many parents appears under each of them. The edge
+// which makes a cycle is cut, so the tree is always finite
+func (t *Topology) Tree(host string) (*PrometheusNode, error) {
+	if _, ok := t.Nodes[host]; !ok {
+		return nil, fmt.Errorf("prometheus host %s not found", host)
+	}
+	edges := map[[2]string]*FederationEdge{}
+	for _, edge := range t.Edges {
+		edges[[2]string{edge.Parent, edge.Child}] = edge.Federation
+	}
+	onPath := map[string]bool{}
+	var build func(host string) *PrometheusNode
+	build = func(host string) *PrometheusNode {
+		node := t.Nodes[host]
+		pn := &PrometheusNode{
+			PrometheusHost:   node.PrometheusHost,
+			AgentHost:        node.AgentHost,
+			AgentStatus:      node.AgentStatus,
+			PrometheusStatus: node.PrometheusStatus,
+			LastUpdated:      node.LastUpdated,
+			LastSeen:         node.LastSeen,
+			Stale:            node.Stale,
+		}
+		onPath[host] = true
+		defer delete(onPath, host)
+		for _, child := range node.Children {
+			if onPath[child] == true {
+				log.Warnf("Cut federation from %s to %s which makes a cycle", host, child)
+				continue
+			}
+			childNode := build(child)
+			childNode.Federation = edges[[2]string{host, child}]
+			pn.Children = append(pn.Children, childNode)
+		}
+		return pn
+	}
+	return build(host), nil
+}
+
+// BuildGraphFromConfigs build the whole node tree of offline configs
+// from root, root may be empty if there is only one root in configs
+func BuildGraphFromConfigs(configs []*OfflineConfig, root string) (*PrometheusNode, error) {
+	topology := NewTopologyFromConfigs(configs)
+	if root == "" {
+		roots := topology.Roots()
+		if len(roots) != 1 {
+			return nil, fmt.Errorf("expect one root prometheus, but found %d: %s", len(roots), strings.Join(roots, ", "))
+		}
+		root = roots[0]
+	}
+	return topology.Tree(root)
+}
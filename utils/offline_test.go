@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func loadLintConfigs(t *testing.T, args ...string) []*OfflineConfig {
+	mapping, err := LoadHostMapping("testdata/lint/hosts.yml")
+	assert.Nil(t, err)
+	configs, err := ParseConfigArgs(args, mapping)
+	assert.Nil(t, err)
+	assert.Nil(t, LoadOfflineConfigs(configs))
+	return configs
+}
+
+func TestLoadHostMapping(t *testing.T) {
+	mapping, err := LoadHostMapping("testdata/lint/hosts.yml")
+	assert.Nil(t, err)
+	abs, _ := filepath.Abs("testdata/lint/global.yml")
+	assert.Equal(t, "global-prometheus:9090", mapping[abs])
+	assert.Equal(t, 3, len(mapping))
+
+	_, err = LoadHostMapping("testdata/lint/not-exist.yml")
+	assert.NotNil(t, err)
+}
+
+func TestParseConfigArgs(t *testing.T) {
+	mapping, _ := LoadHostMapping("testdata/lint/hosts.yml")
+	configs, err := ParseConfigArgs([]string{
+		"testdata/lint/global.yml",
+		"other-prometheus:9090=testdata/lint/regional-1.yml",
+	}, mapping)
+	assert.Nil(t, err)
+	assert.Equal(t, "global-prometheus:9090", configs[0].Host)
+	assert.Equal(t, "other-prometheus:9090", configs[1].Host)
+	assert.Equal(t, "testdata/lint/regional-1.yml", configs[1].Path)
+
+	_, err = ParseConfigArgs([]string{"testdata/lint/cycle-a.yml"}, mapping)
+	assert.NotNil(t, err)
+}
+
+func TestLoadOfflineConfigs(t *testing.T) {
+	configs, _ := ParseConfigArgs([]string{
+		"a:9090=testdata/lint/global.yml",
+		"a:9090=testdata/lint/regional-1.yml",
+	}, nil)
+	assert.NotNil(t, LoadOfflineConfigs(configs))
+
+	configs, _ = ParseConfigArgs([]string{"a:9090=testdata/lint/not-exist.yml"}, nil)
+	assert.NotNil(t, LoadOfflineConfigs(configs))
+}
+
+func TestNewTopologyFromConfigs(t *testing.T) {
+	configs := loadLintConfigs(t, "testdata/lint/global.yml", "testdata/lint/regional-1.yml", "testdata/lint/regional-2.yml")
+	topology := NewTopologyFromConfigs(configs)
+	assert.Equal(t, "global-prometheus:9090", topology.Root)
+	assert.Equal(t, 4, len(topology.Nodes))
+	assert.Equal(t, 4, len(topology.Edges))
+	assert.Equal(t, []string{"regional-prometheus-1:9090", "regional-prometheus-2:9090"}, topology.Nodes["leaf-prometheus-1:9090"].Parents)
+	assert.Equal(t, "federate", topology.Edges[0].Federation.JobName)
+
+	configs = []*OfflineConfig{
+		{Host: "cycle-prometheus-a:9090", Path: "testdata/lint/cycle-a.yml"},
+		{Host: "cycle-prometheus-b:9090", Path: "testdata/lint/cycle-b.yml"},
+	}
+	assert.Nil(t, LoadOfflineConfigs(configs))
+	topology = NewTopologyFromConfigs(configs)
+	assert.Equal(t, "", topology.Root)
+	assert.Equal(t, []string{}, topology.Roots())
+}
+
+func TestParseConfigArgsExpand(t *testing.T) {
+	mapping, _ := LoadHostMapping("testdata/lint/hosts.yml")
+	configs, err := ParseConfigArgs([]string{"testdata/lint"}, mapping)
+	assert.Nil(t, err)
+	hosts := []string{}
+	for _, c := range configs {
+		hosts = append(hosts, c.Host)
+	}
+	assert.Equal(t, []string{"global-prometheus:9090", "regional-prometheus-1:9090", "regional-prometheus-2:9090"}, hosts)
+
+	configs, err = ParseConfigArgs([]string{"testdata/lint/regional-*.yml", "testdata/lint/regional-1.yml"}, mapping)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(configs))
+
+	_, err = ParseConfigArgs([]string{"testdata/lint/none-*.yml"}, mapping)
+	assert.NotNil(t, err)
+}
+
+func TestBuildGraphFromConfigs(t *testing.T) {
+	configs := loadLintConfigs(t, "testdata/lint")
+	node, err := BuildGraphFromConfigs(configs, "")
+	assert.Nil(t, err)
+	expect := "\nglobal-prometheus:9090" +
+		"\n--regional-prometheus-1:9090" +
+		"\n----leaf-prometheus-1:9090" +
+		"\n--regional-prometheus-2:9090" +
+		"\n----leaf-prometheus-1:9090"
+	if tree := node.PrintNodesTree("--", 0, false); tree != expect {
+		t.Fatalf("Expect tree %s, but got %s", expect, tree)
+	}
+	assert.Equal(t, "federate", node.Children[0].Federation.JobName)
+	assert.Equal(t, "regional-prometheus-2:9090", node.Children[1].PrometheusHost)
+
+	node, err = BuildGraphFromConfigs(configs, "regional-prometheus-2:9090")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(node.Children))
+	_, err = BuildGraphFromConfigs(configs, "unknown-prometheus:9090")
+	assert.NotNil(t, err)
+
+	configs = []*OfflineConfig{
+		{Host: "cycle-prometheus-a:9090", Path: "testdata/lint/cycle-a.yml"},
+		{Host: "cycle-prometheus-b:9090", Path: "testdata/lint/cycle-b.yml"},
+	}
+	assert.Nil(t, LoadOfflineConfigs(configs))
+	_, err = BuildGraphFromConfigs(configs, "")
+	assert.NotNil(t, err)
+	node, err = BuildGraphFromConfigs(configs, "cycle-prometheus-a:9090")
+	assert.Nil(t, err)
+	assert.Equal(t, "\ncycle-prometheus-a:9090\n-cycle-prometheus-b:9090", node.PrintNodesTree("-", 0, false))
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// graphSnapshot is the file format of persisted graph
+type graphSnapshot struct {
+	Time time.Time       `json:"time"`
+	Root *PrometheusNode `json:"root"`
+}
+
+// Save write a snapshot of the graph to path, the file is written
+// to a temporary file first and renamed to avoid partial writes
+func (g *Graph) Save(path string) error {
+	data, err := json.Marshal(graphSnapshot{Time: time.Now(), Root: g.Snapshot()})
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadGraphSnapshot read the node tree saved by Save
+// and return it with the time it was saved
+func LoadGraphSnapshot(path string) (*PrometheusNode, time.Time, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	var snapshot graphSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, time.Time{}, err
+	}
+	if snapshot.Root == nil || snapshot.Root.PrometheusHost == "" {
+		return nil, time.Time{}, errors.New("snapshot has no root node")
+	}
+	return snapshot.Root, snapshot.Time, nil
+}
+
+// Restore merge the saved tree into graph, the first level children
+// still in graph get back their status and children, the children
+// removed from config since the snapshot are ignored. If graph has no
+// children since config is not loaded yet, all saved children are used
+// and they are checked again on the next successful reload
+func (g *Graph) Restore(saved *PrometheusNode) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if saved.PrometheusHost != g.root {
+		return
+	}
+	for _, child := range saved.Children {
+		g.resolver.ResolveTree(child)
+	}
+	if len(g.childEdges(g.root)) == 0 {
+		g.edges = subtreeEdges(g.edges, saved)
+		g.mergeNodes(saved, time.Time{})
+		return
+	}
+	for _, savedChild := range saved.Children {
+		child, ok := g.nodes[savedChild.PrometheusHost]
+		if !ok || g.hasEdge(g.root, savedChild.PrometheusHost) == false {
+			continue
+		}
+		child.AgentStatus = savedChild.AgentStatus
+		child.PrometheusStatus = savedChild.PrometheusStatus
+		child.LastUpdated = savedChild.LastUpdated
+		child.LastSeen = savedChild.LastSeen
+		edges := subtreeEdges(g.edges, savedChild)
+		if len(g.topology(edges).FindCycles()) > 0 {
+			continue
+		}
+		g.edges = edges
+		g.mergeNodes(savedChild, time.Time{})
+	}
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphSaveAndRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hercules")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "graph.json")
+
+	nodeRoot, _ := NewPrometheusNode(rootHost)
+	nodeRoot.Children = NewPrometheusNodeList(fedsRoot)
+	nodeRoot.Children[0].AgentStatus = true
+	nodeRoot.Children[0].Children = NewPrometheusNodeList(fedsChild11)
+	nodeRoot.Children[0].Children[0].Children = NewPrometheusNodeList(fedsChild111)
+	before := time.Now()
+	assert.Nil(t, NewGraph(nodeRoot).Save(path))
+
+	saved, savedTime, err := LoadGraphSnapshot(path)
+	assert.Nil(t, err)
+	assert.Equal(t, nodeRoot, saved)
+	assert.False(t, savedTime.Before(before.Truncate(time.Second)))
+
+	// source-prometheus-3 removed from config after restart
+	newRoot, _ := NewPrometheusNode(rootHost)
+	newRoot.Children = NewPrometheusNodeList(fedsRoot[:2])
+	graph := NewGraph(newRoot)
+	graph.Restore(saved)
+	assert.True(t, graph.Search(fedsChild111[0], true))
+	assert.False(t, graph.Search(fedsRoot[2], true))
+	graph.Read(func(root *PrometheusNode) {
+		assert.True(t, root.Children[0].AgentStatus)
+		assert.Equal(t, 2, len(root.Children))
+	})
+
+	otherRoot, _ := NewPrometheusNode("other-prometheus:9090")
+	otherRoot.Children = NewPrometheusNodeList(fedsRoot)
+	otherGraph := NewGraph(otherRoot)
+	otherGraph.Restore(saved)
+	assert.False(t, otherGraph.Search(fedsChild11[0], true))
+}
+
+func TestGraphRestoreBeforeConfigLoaded(t *testing.T) {
+	saved, _ := NewPrometheusNode(rootHost)
+	saved.Children = NewPrometheusNodeList(fedsRoot)
+	saved.Children[0].Children = NewPrometheusNodeList(fedsChild11)
+
+	// config from api is not loaded yet, so root has no children
+	nodeRoot, _ := NewPrometheusNode(rootHost)
+	graph := NewGraph(nodeRoot)
+	resolver, _ := NewAgentResolver(29090, "")
+	graph.SetAgentResolver(resolver)
+	graph.Restore(saved)
+	snapshot := graph.Snapshot()
+	assert.Equal(t, len(fedsRoot), len(snapshot.Children))
+	assert.Equal(t, "source-prometheus-1:29090", snapshot.Children[0].AgentHost)
+	assert.True(t, graph.Search(fedsChild11[0], true))
+
+	// the next reload keep the children still in config
+	graph.UpdateChildren(NewPrometheusNodeList(fedsRoot[:1]))
+	assert.True(t, graph.Search(fedsChild11[0], true))
+	assert.False(t, graph.Search(fedsRoot[1], true))
+}
+
+func TestLoadGraphSnapshotWithBadFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hercules")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	_, _, err = LoadGraphSnapshot(filepath.Join(dir, "not-exist.json"))
+	assert.NotNil(t, err)
+	for _, content := range []string{"not json", `{"root": null}`} {
+		path := filepath.Join(dir, "graph.json")
+		ioutil.WriteFile(path, []byte(content), 0644)
+		_, _, err = LoadGraphSnapshot(path)
+		assert.NotNil(t, err)
+	}
+}
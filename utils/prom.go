@@ -1,28 +1,217 @@
 package utils
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/relabel"
 )
 
-// GetFederationHostsFromConfig read prometheus config file
-// and return all target for federation, only static_config support
-func GetFederationHostsFromConfig(path string) ([]string, error) {
-	federations := []string{}
+// AgentLabel is the target label which set the agent address of
+// federation target, labels start with __ will not be stored by prometheus
+const AgentLabel = "__hercules_agent__"
+
+// FederationTarget is a prometheus server federated by current prometheus
+type FederationTarget struct {
+	PrometheusHost string
+	AgentHost      string
+	JobName        string
+	ScrapeInterval model.Duration
+	ScrapeTimeout  model.Duration
+	Scheme         string
+	MetricsPath    string
+	Params         url.Values
+	HonorLabels    bool
+}
+
+// Edge return the federation edge infomation of target
+func (ft FederationTarget) Edge() *FederationEdge {
+	return &FederationEdge{
+		JobName:        ft.JobName,
+		ScrapeInterval: ft.ScrapeInterval.String(),
+		ScrapeTimeout:  ft.ScrapeTimeout.String(),
+		Match:          ft.Params["match[]"],
+		HonorLabels:    ft.HonorLabels,
+		Scheme:         ft.Scheme,
+		MetricsPath:    ft.MetricsPath,
+	}
+}
+
+// IsFederatePath return true if the metrics path is a federate api,
+// path with prefix like /prometheus/federate is also supported
+func IsFederatePath(metricsPath string) bool {
+	metricsPath = path.Clean("/" + metricsPath)
+	return metricsPath == "/federate" || strings.HasSuffix(metricsPath, "/federate")
+}
+
+// GetFederationTargetsFromConfig read prometheus config file
+// and return all target for federation
+func GetFederationTargetsFromConfig(path string) ([]FederationTarget, error) {
+	targets, _, err := loadFederationTargets(path)
+	return targets, err
+}
+
+// loadFederationTargets read prometheus config file and return all target
+// for federation with the refresh interval of its service discovery
+func loadFederationTargets(path string) ([]FederationTarget, time.Duration, error) {
 	conf, err := config.LoadFile(path)
 	if err != nil {
-		return federations, err
+		return []FederationTarget{}, 0, err
 	}
+	return GetFederationTargets(conf, filepath.Dir(path)), RefreshInterval(conf), nil
+}
+
+// GetFederationTargetsFromPrometheus read the running config from
+// prometheus api and return all target for federation
+func GetFederationTargetsFromPrometheus(host string, timeout time.Duration) ([]FederationTarget, error) {
+	conf, err := FetchPrometheusConfig(host, timeout)
+	if err != nil {
+		return []FederationTarget{}, err
+	}
+	return GetFederationTargets(conf, ""), nil
+}
+
+// FetchPrometheusConfig get the running config yaml from
+// prometheus /api/v1/status/config and parse it
+func FetchPrometheusConfig(host string, timeout time.Duration) (*config.Config, error) {
+	url := host + "/api/v1/status/config"
+	if !strings.HasPrefix(url, "http://") {
+		url = "http://" + url
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s return status code %d", url, resp.StatusCode)
+	}
+	var response struct {
+		Status string `json:"status"`
+		Error  string `json:"error"`
+		Data   struct {
+			YAML string `json:"yaml"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+	if response.Status != "success" {
+		return nil, fmt.Errorf("%s return error: %s", url, response.Error)
+	}
+	return config.Load(response.Data.YAML)
+}
+
+// GetFederationTargets return all target for federation in config,
+// relative file_sd path is based on baseDir
+func GetFederationTargets(conf *config.Config, baseDir string) []FederationTarget {
+	targets := []FederationTarget{}
 	for _, sc := range conf.ScrapeConfigs {
-		if sc.MetricsPath == "/federate" {
-			for _, tg := range sc.ServiceDiscoveryConfig.StaticConfigs {
-				for _, t := range tg.Targets {
-					if err == config.CheckTargetAddress(t[model.AddressLabel]) {
-						federations = append(federations, string(t[model.AddressLabel]))
-					}
+		if !mayFederate(sc) {
+			continue
+		}
+		for _, tg := range GetTargetGroups(sc, baseDir) {
+			for _, t := range tg.Targets {
+				if target, ok := classifyTarget(sc, tg.Labels.Merge(t)); ok {
+					targets = append(targets, target)
 				}
 			}
 		}
 	}
+	return targets
+}
+
+// mayFederate return false if the scrape job never scrape federate api,
+// it is used to skip the service discovery of normal jobs
+func mayFederate(sc *config.ScrapeConfig) bool {
+	if IsFederatePath(sc.MetricsPath) {
+		return true
+	}
+	for _, rc := range sc.RelabelConfigs {
+		if rc.TargetLabel == model.MetricsPathLabel || rc.Action == config.RelabelLabelMap {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyTarget apply the relabel configs of scrape job to target
+// labels like prometheus does, and return the target if it scrape
+// the federate api after relabeling
+func classifyTarget(sc *config.ScrapeConfig, labels model.LabelSet) (FederationTarget, bool) {
+	labels = labels.Clone()
+	setDefault := func(name model.LabelName, value string) {
+		if _, ok := labels[name]; !ok {
+			labels[name] = model.LabelValue(value)
+		}
+	}
+	setDefault(model.JobLabel, sc.JobName)
+	setDefault(model.MetricsPathLabel, sc.MetricsPath)
+	setDefault(model.SchemeLabel, sc.Scheme)
+	for name, values := range sc.Params {
+		if len(values) > 0 {
+			setDefault(model.LabelName(model.ParamLabelPrefix+name), values[0])
+		}
+	}
+	labels = relabel.Process(labels, sc.RelabelConfigs...)
+	if labels == nil {
+		return FederationTarget{}, false
+	}
+	address := labels[model.AddressLabel]
+	if address == "" || config.CheckTargetAddress(address) != nil {
+		return FederationTarget{}, false
+	}
+	if !IsFederatePath(string(labels[model.MetricsPathLabel])) {
+		return FederationTarget{}, false
+	}
+
+	params := url.Values{}
+	for name, values := range sc.Params {
+		params[name] = append([]string{}, values...)
+	}
+	for name, value := range labels {
+		if !strings.HasPrefix(string(name), model.ParamLabelPrefix) {
+			continue
+		}
+		param := strings.TrimPrefix(string(name), model.ParamLabelPrefix)
+		if values := params[param]; len(values) > 0 {
+			values[0] = string(value)
+		} else {
+			params[param] = []string{string(value)}
+		}
+	}
+	return FederationTarget{
+		PrometheusHost: string(address),
+		AgentHost:      string(labels[AgentLabel]),
+		JobName:        sc.JobName,
+		ScrapeInterval: sc.ScrapeInterval,
+		ScrapeTimeout:  sc.ScrapeTimeout,
+		Scheme:         string(labels[model.SchemeLabel]),
+		MetricsPath:    string(labels[model.MetricsPathLabel]),
+		Params:         params,
+		HonorLabels:    sc.HonorLabels,
+	}, true
+}
+
+// GetFederationHostsFromConfig read prometheus config file
+// and return all target hosts for federation
+func GetFederationHostsFromConfig(path string) ([]string, error) {
+	federations := []string{}
+	targets, err := GetFederationTargetsFromConfig(path)
+	if err != nil {
+		return federations, err
+	}
+	for _, target := range targets {
+		federations = append(federations, target.PrometheusHost)
+	}
 	return federations, nil
 }
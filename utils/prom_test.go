@@ -1,8 +1,14 @@
 package utils
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/prometheus/prometheus/config"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -20,3 +26,82 @@ func TestGetFederationHostsFromConfig(t *testing.T) {
 	expectFeds = []string{}
 	assert.ElementsMatch(t, expectFeds, feds)
 }
+
+func TestIsFederatePath(t *testing.T) {
+	assert.True(t, IsFederatePath("/federate"))
+	assert.True(t, IsFederatePath("federate"))
+	assert.True(t, IsFederatePath("/prometheus/federate/"))
+	assert.False(t, IsFederatePath("/metrics"))
+	assert.False(t, IsFederatePath("/federate/metrics"))
+	assert.False(t, IsFederatePath("/notfederate"))
+}
+
+func TestGetFederationTargetsWithClassifier(t *testing.T) {
+	targets, err := GetFederationTargetsFromConfig("testdata/prometheus.classify.conf")
+	assert.Nil(t, err)
+	hosts := []string{}
+	for _, target := range targets {
+		hosts = append(hosts, target.PrometheusHost)
+	}
+	assert.Equal(t, []string{"prefix-prometheus-1:9090", "relabel-prometheus-1:9090", "drop-prometheus-1:9090"}, hosts)
+
+	assert.Equal(t, "https", targets[0].Scheme)
+	assert.Equal(t, "/prometheus/federate", targets[0].MetricsPath)
+	assert.Equal(t, []string{`{job="prometheus"}`, `{__name__=~"job:.*"}`}, targets[0].Params["match[]"])
+	assert.True(t, targets[0].HonorLabels)
+
+	assert.Equal(t, "http", targets[1].Scheme)
+	assert.Equal(t, "/federate", targets[1].MetricsPath)
+	assert.Equal(t, []string{`{job="node"}`}, targets[1].Params["match[]"])
+	assert.False(t, targets[1].HonorLabels)
+}
+
+func TestGetFederationTargetsSkipNormalJobs(t *testing.T) {
+	conf, err := config.LoadFile("testdata/prometheus.classify.conf")
+	assert.Nil(t, err)
+	expect := []bool{true, true, true, false}
+	for index, sc := range conf.ScrapeConfigs {
+		assert.Equal(t, expect[index], mayFederate(sc))
+	}
+}
+
+func TestFederationTargetEdge(t *testing.T) {
+	targets, err := GetFederationTargetsFromConfig("testdata/prometheus.classify.conf")
+	assert.Nil(t, err)
+	edge := targets[0].Edge()
+	assert.Equal(t, &FederationEdge{
+		JobName:        "federate-prefix",
+		ScrapeInterval: "15s",
+		ScrapeTimeout:  "10s",
+		Match:          []string{`{job="prometheus"}`, `{__name__=~"job:.*"}`},
+		HonorLabels:    true,
+		Scheme:         "https",
+		MetricsPath:    "/prometheus/federate",
+	}, edge)
+}
+
+func TestGetFederationTargetsFromPrometheus(t *testing.T) {
+	content, err := ioutil.ReadFile("testdata/prometheus.conf")
+	assert.Nil(t, err)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/status/config" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+			"data":   map[string]string{"yaml": string(content)},
+		})
+	}))
+	defer server.Close()
+
+	targets, err := GetFederationTargetsFromPrometheus(server.URL, time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(targets))
+	assert.Equal(t, "source-prometheus-1:9090", targets[0].PrometheusHost)
+
+	_, err = GetFederationTargetsFromPrometheus(server.URL+"/not-exist", time.Second)
+	assert.NotNil(t, err)
+	_, err = GetFederationTargetsFromPrometheus("127.0.0.1:1", time.Second)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,375 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// hopHeaders are the headers only for a single connection,
+// they are not forwarded to the next hop
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// MaxProxyResponseSize is the max bytes of proxied response
+// body in every hop, 0 means no limit
+var MaxProxyResponseSize int64
+
+// ErrResponseTooLarge is returned when the proxied response
+// body is larger than MaxProxyResponseSize
+var ErrResponseTooLarge = errors.New("proxy response too large")
+
+// TimeoutHeader carry the remaining seconds of the whole proxy
+// request, it is decremented at every hop
+const TimeoutHeader = "X-Prometheus-Timeout-Seconds"
+
+// HopHeader carry the index of agent in the proxy chain, the
+// agent received the request from client is hop 0
+const HopHeader = "X-Prometheus-Hop"
+
+// AgentHeader is set to "hercules" on every proxy response written by
+// an agent, a non-2xx response without it did not come from an agent
+const AgentHeader = "X-Prometheus-Agent"
+
+// ProxyTimeout is the max time every hop waits for the response
+// headers of the next hop, the body is streamed without deadline.
+// 0 means only the timeout from header is used
+var ProxyTimeout = time.Minute
+
+// hopMargin is reserved at every hop so the next hop times out
+// before current one and the failing hop can be identified
+const hopMargin = 100 * time.Millisecond
+
+// proxyBufferSize is the size of buffer for streaming response
+const proxyBufferSize = 32 * 1024
+
+// proxyHeaderTimeout is the max time waiting for the response headers
+// of next hop, it still applies when ProxyTimeout is 0 and no
+// TimeoutHeader is given, so a hung upstream can't pin every hop
+const proxyHeaderTimeout = 5 * time.Minute
+
+// proxyClient send the forwarded requests, redirects are
+// returned to the caller instead of followed
+var proxyClient = &http.Client{
+	Transport: newProxyTransport(),
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func newProxyTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = proxyHeaderTimeout
+	return transport
+}
+
+// CopyHeader copy the headers from src to dst except hop-by-hop headers
+func CopyHeader(dst, src http.Header) {
+	for k, values := range src {
+		for _, v := range values {
+			dst.Add(k, v)
+		}
+	}
+	for _, k := range hopHeaders {
+		dst.Del(k)
+	}
+	if connection := src.Get("Connection"); connection != "" {
+		for _, k := range strings.Split(connection, ",") {
+			dst.Del(strings.TrimSpace(k))
+		}
+	}
+}
+
+// NextProxyRequest build the request to next hop from the incoming proxy
+// request, method, body, query string and headers are kept. The request
+// goes to the next agent if proxy chain is not empty, otherwise to the
+// prometheus, and the query string is merged into the prometheus url.
+// The prometheus url uses http unless the request has its scheme
+func NextProxyRequest(r *http.Request) (*http.Request, error) {
+	parseResult, err := GetNextProxyHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	nextStop, nextProxy, request := parseResult[0], parseResult[1], parseResult[2]
+	target := nextStop
+	if nextStop == "" {
+		target = request
+	}
+	u, err := url.Parse(HostURL("", target))
+	if err != nil {
+		return nil, err
+	}
+	if r.URL.RawQuery != "" {
+		if u.RawQuery == "" {
+			u.RawQuery = r.URL.RawQuery
+		} else {
+			u.RawQuery = u.RawQuery + "&" + r.URL.RawQuery
+		}
+	}
+	req, err := http.NewRequest(r.Method, u.String(), r.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = r.ContentLength
+	CopyHeader(req.Header, r.Header)
+	req.Header.Del("X-Prometheus-Target")
+	if nextStop == "" {
+		req.Header.Del("X-Prometheus-Proxy")
+		req.Header.Del("X-Prometheus-Request")
+	} else {
+		req.Header.Set("X-Prometheus-Proxy", nextProxy)
+		req.Header.Set("X-Prometheus-Request", request)
+	}
+	return req.WithContext(r.Context()), nil
+}
+
+// ForwardPrometheusRequest send the proxy request to next hop and
+// return the response, caller must close the response body. The
+// request is cancelled when the client goes away or the response
+// headers don't arrive before the deadline from ProxyTimeout and
+// TimeoutHeader, a long streaming body is not cut by the deadline
+func ForwardPrometheusRequest(r *http.Request) (*http.Response, error) {
+	start := time.Now()
+	hop, timeout, err := parseProxyHeaders(r)
+	if err != nil {
+		return nil, &RequestError{Hop: hop, Err: err}
+	}
+	req, err := NextProxyRequest(r)
+	if err != nil {
+		return nil, &RequestError{Hop: hop, Err: err}
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	// the deadline only covers waiting for response headers
+	var deadline *time.Timer
+	if timeout > 0 {
+		deadline = time.AfterFunc(timeout, cancel)
+	}
+	expired := func() bool {
+		return deadline != nil && !deadline.Stop()
+	}
+	req = req.WithContext(ctx)
+	toAgent := req.Header.Get("X-Prometheus-Request") != ""
+	if toAgent {
+		req.Header.Set(HopHeader, strconv.Itoa(hop+1))
+		if timeout > 0 {
+			remaining := timeout - time.Since(start) - hopMargin
+			if remaining <= 0 {
+				cancel()
+				return nil, &ForwardError{Hop: hop + 1, URL: req.URL.String(), Err: context.DeadlineExceeded, Timeout: true, Elapsed: time.Since(start)}
+			}
+			req.Header.Set(TimeoutHeader, strconv.FormatFloat(remaining.Seconds(), 'f', 3, 64))
+		}
+	} else {
+		req.Header.Del(HopHeader)
+		req.Header.Del(TimeoutHeader)
+	}
+	log.Infof("Forward %s proxy request to %s", req.Method, req.URL)
+	resp, err := proxyClient.Do(req)
+	timedOut := expired()
+	if err == nil && timedOut {
+		// deadline fired right after headers arrived, body is cancelled
+		resp.Body.Close()
+		err = context.DeadlineExceeded
+	}
+	if err != nil {
+		forwardErr := &ForwardError{
+			Hop:     hop + 1,
+			URL:     req.URL.String(),
+			Err:     err,
+			Timeout: timedOut,
+			Elapsed: time.Since(start),
+		}
+		cancel()
+		return nil, forwardErr
+	}
+	if toAgent && !isSuccess(resp.StatusCode) && resp.Header.Get(AgentHeader) == "" {
+		// wrong path, old agent or other server, the body is not ours
+		resp.Body.Close()
+		cancel()
+		return nil, &ForwardError{
+			Hop:            hop + 1,
+			URL:            req.URL.String(),
+			Err:            fmt.Errorf("next hop is not an agent, status code %d", resp.StatusCode),
+			UpstreamStatus: resp.StatusCode,
+			Elapsed:        time.Since(start),
+		}
+	}
+	if MaxProxyResponseSize > 0 && resp.ContentLength > MaxProxyResponseSize {
+		resp.Body.Close()
+		cancel()
+		return nil, &ForwardError{
+			Hop:            hop + 1,
+			URL:            req.URL.String(),
+			Err:            ErrResponseTooLarge,
+			UpstreamStatus: resp.StatusCode,
+			Elapsed:        time.Since(start),
+		}
+	}
+	resp.Body = &cancelReadCloser{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+func isSuccess(code int) bool {
+	return code >= 200 && code < 300
+}
+
+// parseProxyHeaders return the hop index of current agent and the
+// timeout for next hop, which is the smaller one of ProxyTimeout
+// and the remaining time in header. Hop is 0 if its header is invalid
+func parseProxyHeaders(r *http.Request) (hop int, timeout time.Duration, err error) {
+	if value := r.Header.Get(HopHeader); value != "" {
+		if hop, err = strconv.Atoi(value); err != nil || hop < 0 {
+			return 0, 0, fmt.Errorf("invalid %s header %s", HopHeader, value)
+		}
+	}
+	timeout = ProxyTimeout
+	if value := r.Header.Get(TimeoutHeader); value != "" {
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil || seconds <= 0 {
+			return hop, 0, fmt.Errorf("invalid %s header %s", TimeoutHeader, value)
+		}
+		if remaining := time.Duration(seconds * float64(time.Second)); timeout == 0 || remaining < timeout {
+			timeout = remaining
+		}
+	}
+	return hop, timeout, nil
+}
+
+// cancelReadCloser release the context of request when
+// the response body is closed
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelReadCloser) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// RequestError is returned when the proxy request received by
+// current agent is invalid, Hop is the index of current agent
+type RequestError struct {
+	Hop int
+	Err error
+}
+
+func (e *RequestError) Error() string {
+	return e.Err.Error()
+}
+
+// ForwardError is returned when the next hop can not be reached,
+// does not respond in time or responds too large body
+type ForwardError struct {
+	Hop            int
+	URL            string
+	Err            error
+	Timeout        bool
+	UpstreamStatus int
+	Elapsed        time.Duration
+}
+
+func (e *ForwardError) Error() string {
+	if e.Timeout {
+		return fmt.Sprintf("hop %d %s timeout after %s", e.Hop, e.URL, e.Elapsed.Round(time.Millisecond))
+	}
+	return fmt.Sprintf("hop %d %s fail: %s", e.Hop, e.URL, e.Err)
+}
+
+// ProxyError is the json error response of proxy in prometheus
+// api style, it tells which hop of the proxy chain is failing
+type ProxyError struct {
+	Status         string  `json:"status"`
+	ErrorType      string  `json:"errorType"`
+	Error          string  `json:"error"`
+	Hop            int     `json:"hop"`
+	Agent          string  `json:"agent"`
+	UpstreamStatus int     `json:"upstreamStatus,omitempty"`
+	Elapsed        float64 `json:"elapsed"`
+}
+
+// NewProxyError return the status code and json error of err returned
+// by ForwardPrometheusRequest. The error not from the next hop is caused
+// by the request, so it is reported as the current agent, other errors
+// happen before forwarding on the agent receiving the client request,
+// which is hop 0
+func NewProxyError(err error, r *http.Request, elapsed time.Duration) (int, *ProxyError) {
+	proxyErr := &ProxyError{
+		Status:    "error",
+		ErrorType: "bad_data",
+		Error:     err.Error(),
+		Agent:     r.Host,
+		Elapsed:   elapsed.Seconds(),
+	}
+	if requestErr, ok := err.(*RequestError); ok {
+		proxyErr.Hop = requestErr.Hop
+		return http.StatusBadRequest, proxyErr
+	}
+	forwardErr, ok := err.(*ForwardError)
+	if !ok {
+		return http.StatusBadRequest, proxyErr
+	}
+	proxyErr.Hop = forwardErr.Hop
+	proxyErr.Agent = forwardErr.URL
+	if u, err := url.Parse(forwardErr.URL); err == nil && u.Host != "" {
+		proxyErr.Agent = u.Host
+	}
+	proxyErr.UpstreamStatus = forwardErr.UpstreamStatus
+	proxyErr.Elapsed = forwardErr.Elapsed.Seconds()
+	if forwardErr.Timeout {
+		proxyErr.ErrorType = "timeout"
+		return http.StatusGatewayTimeout, proxyErr
+	}
+	proxyErr.ErrorType = "unavailable"
+	return http.StatusBadGateway, proxyErr
+}
+
+// StreamResponse copy the body to w with a bounded buffer and flush
+// after every chunk, so the response is never held in memory. Return
+// ErrResponseTooLarge once more than limit bytes are read, the bytes
+// over limit are not written, limit 0 means no limit
+func StreamResponse(w io.Writer, body io.Reader, limit int64) (int64, error) {
+	flusher, _ := w.(http.Flusher)
+	buf := make([]byte, proxyBufferSize)
+	var written int64
+	for {
+		n, readErr := body.Read(buf)
+		if n > 0 {
+			if limit > 0 && written+int64(n) > limit {
+				return written, ErrResponseTooLarge
+			}
+			m, err := w.Write(buf[:n])
+			written += int64(m)
+			if err != nil {
+				return written, err
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if readErr == io.EOF {
+			return written, nil
+		}
+		if readErr != nil {
+			return written, readErr
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCopyHeader(t *testing.T) {
+	src := http.Header{}
+	src.Set("Content-Type", "application/json")
+	src.Add("Accept", "a")
+	src.Add("Accept", "b")
+	src.Set("Connection", "keep-alive, X-Hop")
+	src.Set("X-Hop", "1")
+	src.Set("Transfer-Encoding", "chunked")
+	dst := http.Header{}
+	CopyHeader(dst, src)
+	assert.Equal(t, "application/json", dst.Get("Content-Type"))
+	assert.Equal(t, []string{"a", "b"}, dst["Accept"])
+	assert.Equal(t, "", dst.Get("Connection"))
+	assert.Equal(t, "", dst.Get("X-Hop"))
+	assert.Equal(t, "", dst.Get("Transfer-Encoding"))
+}
+
+func TestNextProxyRequest(t *testing.T) {
+	r, _ := http.NewRequest("POST", "/proxy?timeout=5s", strings.NewReader("query=up"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Prometheus-Proxy", "a.com:19090/proxy;b.com:19090/proxy")
+	r.Header.Set("X-Prometheus-Request", "c.com:9090/api/v1/query?dedup=true")
+	r.Header.Set("X-Prometheus-Target", "c.com:9090")
+	req, err := NextProxyRequest(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "POST", req.Method)
+	assert.Equal(t, "http://a.com:19090/proxy?timeout=5s", req.URL.String())
+	assert.Equal(t, "b.com:19090/proxy", req.Header.Get("X-Prometheus-Proxy"))
+	assert.Equal(t, "c.com:9090/api/v1/query?dedup=true", req.Header.Get("X-Prometheus-Request"))
+	assert.Equal(t, "", req.Header.Get("X-Prometheus-Target"))
+	assert.Equal(t, "application/x-www-form-urlencoded", req.Header.Get("Content-Type"))
+	body, _ := ioutil.ReadAll(req.Body)
+	assert.Equal(t, "query=up", string(body))
+
+	r, _ = http.NewRequest("GET", "/proxy?timeout=5s", nil)
+	r.Header.Set("X-Prometheus-Request", "c.com:9090/api/v1/query?dedup=true")
+	req, err = NextProxyRequest(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "http://c.com:9090/api/v1/query?dedup=true&timeout=5s", req.URL.String())
+	assert.Equal(t, "", req.Header.Get("X-Prometheus-Request"))
+
+	r.Header.Set("X-Prometheus-Request", "https://c.com:9090/api/v1/query")
+	req, err = NextProxyRequest(r)
+	assert.Nil(t, err)
+	assert.Equal(t, "https://c.com:9090/api/v1/query?timeout=5s", req.URL.String())
+
+	r, _ = http.NewRequest("GET", "/proxy", nil)
+	_, err = NextProxyRequest(r)
+	assert.NotNil(t, err)
+}
+
+type flushRecorder struct {
+	strings.Builder
+	flushed int
+}
+
+func (f *flushRecorder) Flush() {
+	f.flushed++
+}
+
+func TestStreamResponse(t *testing.T) {
+	body := strings.Repeat("x", proxyBufferSize+10)
+	w := &flushRecorder{}
+	n, err := StreamResponse(w, strings.NewReader(body), 0)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(len(body)), n)
+	assert.Equal(t, body, w.String())
+	assert.True(t, w.flushed >= 2)
+
+	w = &flushRecorder{}
+	n, err = StreamResponse(w, strings.NewReader(body), int64(len(body)))
+	assert.Nil(t, err)
+
+	w = &flushRecorder{}
+	n, err = StreamResponse(w, strings.NewReader(body), proxyBufferSize)
+	assert.Equal(t, ErrResponseTooLarge, err)
+	assert.Equal(t, int64(proxyBufferSize), n)
+}
+
+func TestParseProxyHeaders(t *testing.T) {
+	defer func(timeout time.Duration) { ProxyTimeout = timeout }(ProxyTimeout)
+	ProxyTimeout = 10 * time.Second
+	r, _ := http.NewRequest("GET", "/proxy", nil)
+	hop, timeout, err := parseProxyHeaders(r)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, hop)
+	assert.Equal(t, 10*time.Second, timeout)
+
+	r.Header.Set(HopHeader, "2")
+	r.Header.Set(TimeoutHeader, "1.5")
+	hop, timeout, err = parseProxyHeaders(r)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, hop)
+	assert.Equal(t, 1500*time.Millisecond, timeout)
+
+	r.Header.Set(TimeoutHeader, "30")
+	_, timeout, _ = parseProxyHeaders(r)
+	assert.Equal(t, 10*time.Second, timeout)
+	ProxyTimeout = 0
+	_, timeout, _ = parseProxyHeaders(r)
+	assert.Equal(t, 30*time.Second, timeout)
+
+	r.Header.Set(TimeoutHeader, "-1")
+	hop, _, err = parseProxyHeaders(r)
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, hop)
+	r.Header.Set(TimeoutHeader, "1")
+	r.Header.Set(HopHeader, "a")
+	_, _, err = parseProxyHeaders(r)
+	assert.NotNil(t, err)
+}
+
+func TestForwardPrometheusRequestTimeout(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header
+		select {
+		case <-r.Context().Done():
+		case <-time.After(2 * time.Second):
+		}
+	}))
+	defer server.Close()
+	r, _ := http.NewRequest("GET", "/proxy", nil)
+	r.Header.Set(HopHeader, "1")
+	r.Header.Set(TimeoutHeader, "0.3")
+	r.Header.Set("X-Prometheus-Request", strings.TrimPrefix(server.URL, "http://")+"/api/v1/query")
+	_, err := ForwardPrometheusRequest(r)
+	forwardErr, ok := err.(*ForwardError)
+	if !ok {
+		t.Fatalf("Expect forward error, but got %v", err)
+	}
+	assert.True(t, forwardErr.Timeout)
+	assert.Equal(t, 2, forwardErr.Hop)
+	assert.True(t, forwardErr.Elapsed >= 300*time.Millisecond)
+	header := <-headers
+	assert.Equal(t, "", header.Get(HopHeader))
+	assert.Equal(t, "", header.Get(TimeoutHeader))
+
+	r.Header.Set(TimeoutHeader, "0.05")
+	_, err = ForwardPrometheusRequest(r)
+	forwardErr, ok = err.(*ForwardError)
+	assert.True(t, ok && forwardErr.Timeout)
+}
+
+func TestForwardPrometheusRequestSlowBody(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < 5; i++ {
+			fmt.Fprintf(w, "chunk-%d\n", i)
+			w.(http.Flusher).Flush()
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer server.Close()
+	r, _ := http.NewRequest("GET", "/proxy", nil)
+	r.Header.Set(TimeoutHeader, "0.2")
+	r.Header.Set("X-Prometheus-Request", strings.TrimPrefix(server.URL, "http://")+"/federate")
+	resp, err := ForwardPrometheusRequest(r)
+	if err != nil {
+		t.Fatalf("Expect response headers in time, but got %s", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Expect body streamed after deadline, but got %s", err)
+	}
+	assert.Equal(t, 5, strings.Count(string(body), "chunk-"))
+}
+
+func TestNewProxyError(t *testing.T) {
+	r, _ := http.NewRequest("GET", "http://agent-1:19090/proxy", nil)
+	code, proxyErr := NewProxyError(&RequestError{Hop: 1, Err: errors.New("Proxy Chain Broken")}, r, time.Second)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, &ProxyError{
+		Status:    "error",
+		ErrorType: "bad_data",
+		Error:     "Proxy Chain Broken",
+		Hop:       1,
+		Agent:     "agent-1:19090",
+		Elapsed:   1,
+	}, proxyErr)
+
+	// errors before forwarding are reported as the entry agent
+	r.Header.Set(HopHeader, "1")
+	code, proxyErr = NewProxyError(errors.New("no route to target"), r, time.Second)
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, 0, proxyErr.Hop)
+
+	code, proxyErr = NewProxyError(&ForwardError{
+		Hop:     2,
+		URL:     "http://agent-2:19090/proxy",
+		Err:     context.DeadlineExceeded,
+		Timeout: true,
+		Elapsed: 1500 * time.Millisecond,
+	}, r, time.Second)
+	assert.Equal(t, http.StatusGatewayTimeout, code)
+	assert.Equal(t, "timeout", proxyErr.ErrorType)
+	assert.Equal(t, 2, proxyErr.Hop)
+	assert.Equal(t, "agent-2:19090", proxyErr.Agent)
+	assert.Equal(t, 1.5, proxyErr.Elapsed)
+
+	code, proxyErr = NewProxyError(&ForwardError{
+		Hop:            3,
+		URL:            "http://prometheus:9090/federate",
+		Err:            ErrResponseTooLarge,
+		UpstreamStatus: http.StatusOK,
+	}, r, time.Second)
+	assert.Equal(t, http.StatusBadGateway, code)
+	assert.Equal(t, "unavailable", proxyErr.ErrorType)
+	assert.Equal(t, "prometheus:9090", proxyErr.Agent)
+	assert.Equal(t, http.StatusOK, proxyErr.UpstreamStatus)
+	assert.Equal(t, "hop 3 http://prometheus:9090/federate fail: proxy response too large", proxyErr.Error)
+}
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ParseParentHosts split the comma separated parent agent list
+func ParseParentHosts(parents string) []string {
+	hosts := []string{}
+	for _, parent := range strings.Split(parents, ",") {
+		parent = strings.TrimSpace(parent)
+		if parent != "" {
+			hosts = append(hosts, parent)
+		}
+	}
+	return hosts
+}
+
+// PushGraph send current node and its subtree to the parent
+// agent, parent agent will merge it into its own graph
+func PushGraph(parent string, pn *PrometheusNode, timeout time.Duration) error {
+	if parent == "" {
+		return errors.New("parent host is empty")
+	}
+	url := parent + "/update-graph"
+	if !strings.HasPrefix(url, "http://") {
+		url = "http://" + url
+	}
+	data, err := json.Marshal(pn)
+	if err != nil {
+		return err
+	}
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("push graph to %s return status code %d", parent, resp.StatusCode)
+	}
+	return nil
+}
+
+// PushGraphToParents send current node to all parents concurrently
+func PushGraphToParents(parents []string, pn *PrometheusNode, timeout time.Duration) {
+	var wg sync.WaitGroup
+	for _, parent := range parents {
+		wg.Add(1)
+		go func(parent string) {
+			defer wg.Done()
+			if err := PushGraph(parent, pn, timeout); err != nil {
+				log.Errorf("Push graph to parent %s fail: %s", parent, err)
+				return
+			}
+			log.Debugf("Push graph to parent %s success", parent)
+		}(parent)
+	}
+	wg.Wait()
+}
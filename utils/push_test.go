@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseParentHosts(t *testing.T) {
+	assert.Equal(t, []string{}, ParseParentHosts(""))
+	assert.Equal(t, []string{"a.com:19090", "b.com:19090"}, ParseParentHosts(" a.com:19090,,b.com:19090 "))
+}
+
+func TestPushGraph(t *testing.T) {
+	parent, _ := NewPrometheusNode(rootHost)
+	parent.Children = NewPrometheusNodeList(fedsRoot)
+	graph := NewGraph(parent)
+	server := httptest.NewServer(http.HandlerFunc(UpdateGraph(graph)))
+	defer server.Close()
+
+	child, _ := NewPrometheusNode(fedsRoot[0])
+	child.Children = NewPrometheusNodeList(fedsChild11)
+	err := PushGraph(server.URL, child, time.Second)
+	assert.Nil(t, err)
+	assert.True(t, graph.Search(fedsChild11[0], true))
+	assert.Equal(t, len(fedsRoot), len(graph.Snapshot().Children))
+
+	PushGraphToParents([]string{server.URL, "127.0.0.1:1"}, child, time.Second)
+	assert.Equal(t, len(fedsChild11), len(graph.Snapshot().Children[0].Children))
+
+	err = PushGraph("127.0.0.1:1", child, time.Second)
+	assert.NotNil(t, err)
+	err = PushGraph("", child, time.Second)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,220 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// PathTo return the shortest path from current node to the host,
+// nil if the host is not found
+func (pn *PrometheusNode) PathTo(host string) []string {
+	var shortest []string
+	for _, path := range pn.SearchPaths(host) {
+		if shortest == nil || len(path) < len(shortest) {
+			shortest = path
+		}
+	}
+	return shortest
+}
+
+// Depth return the depth of the nearest node matched the host,
+// current node is 0 and -1 means not found
+func (pn *PrometheusNode) Depth(host string) int {
+	return len(pn.PathTo(host)) - 1
+}
+
+// Find return the nearest node matched the host or nil
+func (pn *PrometheusNode) Find(host string) *PrometheusNode {
+	nodes := pn.nodesOnPath(pn.PathTo(host))
+	if len(nodes) == 0 {
+		return nil
+	}
+	return nodes[len(nodes)-1]
+}
+
+// nodesOnPath return the nodes along the path of hosts
+// from current node, nil if path is broken
+func (pn *PrometheusNode) nodesOnPath(path []string) []*PrometheusNode {
+	if len(path) == 0 || path[0] != pn.PrometheusHost {
+		return nil
+	}
+	nodes := []*PrometheusNode{pn}
+	for _, host := range path[1:] {
+		var next *PrometheusNode
+		for _, child := range nodes[len(nodes)-1].Children {
+			if child.PrometheusHost == host {
+				next = child
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		nodes = append(nodes, next)
+	}
+	return nodes
+}
+
+// Ancestors return the hosts above every node matched the host,
+// a host federated by many parents has all of them
+func (pn *PrometheusNode) Ancestors(host string) []string {
+	ancestors := []string{}
+	seen := map[string]bool{}
+	for _, path := range pn.SearchPaths(host) {
+		for _, ancestor := range path[:len(path)-1] {
+			if seen[ancestor] == false {
+				seen[ancestor] = true
+				ancestors = append(ancestors, ancestor)
+			}
+		}
+	}
+	return ancestors
+}
+
+// Descendants return the hosts under every node matched the host
+func (pn *PrometheusNode) Descendants(host string) []string {
+	descendants := []string{}
+	seen := map[string]bool{}
+	var walk func(node *PrometheusNode)
+	walk = func(node *PrometheusNode) {
+		for _, child := range node.Children {
+			if seen[child.PrometheusHost] == false {
+				seen[child.PrometheusHost] = true
+				descendants = append(descendants, child.PrometheusHost)
+			}
+			walk(child)
+		}
+	}
+	if pn.PrometheusHost == host {
+		walk(pn)
+	}
+	pn.searchAll(host, walk)
+	return descendants
+}
+
+// SubtreeSize return the number of nodes in the subtree,
+// including current node
+func (pn *PrometheusNode) SubtreeSize() int {
+	size := 1
+	for _, child := range pn.Children {
+		size += child.SubtreeSize()
+	}
+	return size
+}
+
+// ProxyChain return the X-Prometheus-Proxy header value for sending
+// request from agent of current node to the prometheus at the end of
+// path, it is the agents of nodes on the path except current node
+func (pn *PrometheusNode) ProxyChain(path []string) (string, error) {
+	nodes := pn.nodesOnPath(path)
+	if nodes == nil {
+		return "", fmt.Errorf("path %s not found", strings.Join(path, " -> "))
+	}
+	agents := []string{}
+	for _, node := range nodes[1:] {
+		agents = append(agents, node.GetAgentHost()+"/proxy")
+	}
+	return strings.Join(agents, ";"), nil
+}
+
+// PathResult is the shortest path from root to the host
+// and the proxy chain along it
+type PathResult struct {
+	Host  string   `json:"host"`
+	Path  []string `json:"path"`
+	Depth int      `json:"depth"`
+	Proxy string   `json:"proxy"`
+}
+
+// NodeResult is the node matched the host and its
+// position in the graph
+type NodeResult struct {
+	Node        *PrometheusNode `json:"node"`
+	Depth       int             `json:"depth"`
+	Paths       [][]string      `json:"paths"`
+	Ancestors   []string        `json:"ancestors"`
+	Descendants []string        `json:"descendants"`
+	SubtreeSize int             `json:"subtree_size"`
+}
+
+// queryGraph return a http handler which call fn with the root and
+// host in query under read lock, fn return nil when host not found
+func queryGraph(g *Graph, fn func(root *PrometheusNode, host string) interface{}) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.URL.Query().Get("host")
+		if host == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Host is required"))
+			return
+		}
+		var data interface{}
+		g.Read(func(root *PrometheusNode) {
+			data = fn(root, host)
+		})
+		if data == nil {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprintf(w, "Host %s not found", host)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(data)
+	}
+}
+
+// GetPath will return a http handler function which return
+// the path from root to host and the proxy chain along it
+func GetPath(g *Graph) func(w http.ResponseWriter, r *http.Request) {
+	return queryGraph(g, func(root *PrometheusNode, host string) interface{} {
+		path := root.PathTo(host)
+		if path == nil {
+			return nil
+		}
+		proxy, _ := root.ProxyChain(path)
+		return &PathResult{Host: host, Path: path, Depth: len(path) - 1, Proxy: proxy}
+	})
+}
+
+// GetNode will return a http handler function which return the
+// node of host with its ancestors, descendants and subtree size
+func GetNode(g *Graph) func(w http.ResponseWriter, r *http.Request) {
+	return queryGraph(g, func(root *PrometheusNode, host string) interface{} {
+		node := root.Find(host)
+		if node == nil {
+			return nil
+		}
+		info := *node
+		info.Children = nil
+		return &NodeResult{
+			Node:        info.Clone(),
+			Depth:       root.Depth(host),
+			Paths:       root.SearchPaths(host),
+			Ancestors:   root.Ancestors(host),
+			Descendants: root.Descendants(host),
+			SubtreeSize: node.SubtreeSize(),
+		}
+	})
+}
+
+// ProxyRoute return the X-Prometheus-Proxy header value for sending
+// request from current agent to the target prometheus, following the
+// shortest path in graph
+func (g *Graph) ProxyRoute(target string) (string, error) {
+	root := g.Snapshot()
+	path := root.PathTo(target)
+	if path == nil {
+		return "", fmt.Errorf("target %s not found in graph", target)
+	}
+	return root.ProxyChain(path)
+}
+
+// PrometheusURL return the url of target prometheus with the scheme
+// its parent scrapes it with, http is used if target is not in graph
+func (g *Graph) PrometheusURL(target string) string {
+	if node := g.Snapshot().Find(target); node != nil {
+		return HostURL(node.Scheme(), target)
+	}
+	return HostURL("", target)
+}
@@ -0,0 +1,94 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusNodeQuery(t *testing.T) {
+	node := newHATree()
+	node.Children[1].AgentHost = "global-agent-2:8080"
+
+	assert.Equal(t, []string{rootHost}, node.PathTo(rootHost))
+	assert.Equal(t, []string{rootHost, globalHosts[0], regionalHosts[1]}, node.PathTo(regionalHosts[1]))
+	assert.Nil(t, node.PathTo("unknown:9090"))
+
+	assert.Equal(t, 0, node.Depth(rootHost))
+	assert.Equal(t, 2, node.Depth(regionalHosts[0]))
+	assert.Equal(t, -1, node.Depth("unknown:9090"))
+
+	assert.Equal(t, globalHosts[1], node.Find(globalHosts[1]).PrometheusHost)
+	assert.Nil(t, node.Find("unknown:9090"))
+
+	assert.Equal(t, []string{rootHost, globalHosts[0], globalHosts[1]}, node.Ancestors(regionalHosts[0]))
+	assert.Equal(t, []string{}, node.Ancestors(rootHost))
+
+	assert.Equal(t, regionalHosts, node.Descendants(globalHosts[1]))
+	assert.Equal(t, []string{globalHosts[0], regionalHosts[0], regionalHosts[1], globalHosts[1]}, node.Descendants(rootHost))
+	assert.Equal(t, []string{}, node.Descendants(regionalHosts[0]))
+
+	assert.Equal(t, 7, node.SubtreeSize())
+	assert.Equal(t, 3, node.Children[0].SubtreeSize())
+
+	proxy, err := node.ProxyChain([]string{rootHost, globalHosts[1], regionalHosts[0]})
+	assert.Nil(t, err)
+	assert.Equal(t, "global-agent-2:8080/proxy;regional-prometheus-1:19090/proxy", proxy)
+	proxy, err = node.ProxyChain([]string{rootHost})
+	assert.Nil(t, err)
+	assert.Equal(t, "", proxy)
+	_, err = node.ProxyChain([]string{rootHost, regionalHosts[0]})
+	assert.NotNil(t, err)
+}
+
+func TestGraphProxyRoute(t *testing.T) {
+	graph := NewGraph(newHATree())
+	proxy, err := graph.ProxyRoute(regionalHosts[1])
+	assert.Nil(t, err)
+	assert.Equal(t, "global-prometheus-1:19090/proxy;regional-prometheus-2:19090/proxy", proxy)
+	_, err = graph.ProxyRoute("unknown:9090")
+	assert.NotNil(t, err)
+}
+
+func TestGetPathAndNode(t *testing.T) {
+	graph := NewGraph(newHATree())
+	pathHandler := http.HandlerFunc(GetPath(graph))
+	nodeHandler := http.HandlerFunc(GetNode(graph))
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/graph/path?host="+regionalHosts[0], nil)
+	pathHandler.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var path PathResult
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &path))
+	assert.Equal(t, 2, path.Depth)
+	assert.Equal(t, "global-prometheus-1:19090/proxy;regional-prometheus-1:19090/proxy", path.Proxy)
+
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/graph/node?host="+globalHosts[0], nil)
+	nodeHandler.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var node NodeResult
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &node))
+	assert.Equal(t, globalHosts[0], node.Node.PrometheusHost)
+	assert.Nil(t, node.Node.Children)
+	assert.Equal(t, 1, node.Depth)
+	assert.Equal(t, []string{rootHost}, node.Ancestors)
+	assert.Equal(t, regionalHosts, node.Descendants)
+	assert.Equal(t, 3, node.SubtreeSize)
+
+	for _, handler := range []http.HandlerFunc{pathHandler, nodeHandler} {
+		recorder = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/graph/node?host=unknown:9090", nil)
+		handler.ServeHTTP(recorder, req)
+		assert.Equal(t, http.StatusNotFound, recorder.Code)
+
+		recorder = httptest.NewRecorder()
+		req, _ = http.NewRequest("GET", "/graph/node", nil)
+		handler.ServeHTTP(recorder, req)
+		assert.Equal(t, http.StatusBadRequest, recorder.Code)
+	}
+}
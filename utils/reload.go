@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Reloader read prometheus config again and update the children of graph,
+// the config is read from file or the prometheus api
+type Reloader struct {
+	graph    *Graph
+	path     string
+	host     string
+	timeout  time.Duration
+	resolver *AgentResolver
+	mutex    sync.Mutex
+	modTime  time.Time
+	size     int64
+	// refresh is the service discovery refresh interval of config
+	// file, targets are resolved again after it since last load
+	refresh  time.Duration
+	lastLoad time.Time
+}
+
+// NewReloader create a reloader for graph with config file path
+func NewReloader(graph *Graph, path string, resolver *AgentResolver) *Reloader {
+	r := &Reloader{graph: graph, path: path, resolver: resolver}
+	r.isModified()
+	return r
+}
+
+// NewAPIReloader create a reloader for graph which read
+// the running config from prometheus host
+func NewAPIReloader(graph *Graph, host string, timeout time.Duration, resolver *AgentResolver) *Reloader {
+	return &Reloader{graph: graph, host: host, timeout: timeout, resolver: resolver}
+}
+
+func (r *Reloader) source() string {
+	if r.path != "" {
+		return r.path
+	}
+	return r.host + "/api/v1/status/config"
+}
+
+func (r *Reloader) loadTargets() ([]FederationTarget, error) {
+	if r.path != "" {
+		targets, refresh, err := loadFederationTargets(r.path)
+		if err == nil {
+			r.refresh = refresh
+			r.lastLoad = time.Now()
+		}
+		return targets, err
+	}
+	return GetFederationTargetsFromPrometheus(r.host, r.timeout)
+}
+
+// Reload read the config and update children of graph
+func (r *Reloader) Reload() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	targets, err := r.loadTargets()
+	if err != nil {
+		return err
+	}
+	added, removed := r.graph.UpdateChildren(NewPrometheusNodeListWithAgent(targets, r.resolver))
+	if len(added) > 0 || len(removed) > 0 {
+		log.Infof("Reload config %s, add %v, remove %v", r.source(), added, removed)
+	}
+	return nil
+}
+
+// isModified check the modify time and size of config file,
+// config from prometheus api is always treated as modified
+func (r *Reloader) isModified() bool {
+	if r.path == "" {
+		return true
+	}
+	info, err := os.Stat(r.path)
+	if err != nil {
+		log.Warnf("Stat config %s fail: %s", r.path, err)
+		return false
+	}
+	if info.ModTime().Equal(r.modTime) && info.Size() == r.size {
+		return false
+	}
+	r.modTime = info.ModTime()
+	r.size = info.Size()
+	return true
+}
+
+// needRefresh return true if the service discovery targets of config
+// file are older than its refresh interval, like file_sd files changed
+// or dns records updated without touching the config file
+func (r *Reloader) needRefresh(now time.Time) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.refresh > 0 && now.Sub(r.lastLoad) >= r.refresh
+}
+
+// Watch check the config every interval and reload when it is
+// changed or service discovery need refresh, until quit is closed
+func (r *Reloader) Watch(interval time.Duration, quit <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if r.isModified() == false && r.needRefresh(time.Now()) == false {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				log.Errorf("Reload config %s fail: %s", r.source(), err)
+			}
+		case <-quit:
+			return
+		}
+	}
+}
+
+// RetryReload call Reload until it succeeds, the wait between tries
+// is doubled from min up to max. Return false if quit is closed first
+func (r *Reloader) RetryReload(min, max time.Duration, quit <-chan struct{}) bool {
+	wait := min
+	for {
+		err := r.Reload()
+		if err == nil {
+			return true
+		}
+		log.Errorf("Load config %s fail, retry in %s: %s", r.source(), wait, err)
+		select {
+		case <-time.After(wait):
+		case <-quit:
+			return false
+		}
+		wait *= 2
+		if wait > max {
+			wait = max
+		}
+	}
+}
+
+// ReloadHandler will reload the config when receive post request
+func ReloadHandler(r *Reloader) func(w http.ResponseWriter, req *http.Request) {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			w.Write([]byte("Only POST method allowed"))
+			return
+		}
+		if err := r.Reload(); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintf(w, "Reload fail: %s", err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+}
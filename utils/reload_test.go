@@ -0,0 +1,205 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const reloadConfig = `
+scrape_configs:
+- job_name: 'federate'
+  metrics_path: '/federate'
+  static_configs:
+    - targets: [%s]
+`
+
+func writeReloadConfig(t *testing.T, path string, targets string) {
+	content := fmt.Sprintf(reloadConfig, targets)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGraphUpdateChildren(t *testing.T) {
+	nodeRoot, _ := NewPrometheusNode(rootHost)
+	nodeRoot.Children = NewPrometheusNodeList(fedsRoot)
+	nodeRoot.Children[0].AgentStatus = true
+	nodeRoot.Children[0].Children = NewPrometheusNodeList(fedsChild11)
+	nodeRoot.Children[1].Children = NewPrometheusNodeList(fedsChild21)
+	graph := NewGraph(nodeRoot)
+
+	newChildren := NewPrometheusNodeList([]string{fedsRoot[0], "source-prometheus-4:9090"})
+	newChildren[0].AgentHost = "agent-1:19090"
+	added, removed := graph.UpdateChildren(newChildren)
+	assert.Equal(t, []string{"source-prometheus-4:9090"}, added)
+	assert.Equal(t, []string{fedsRoot[1], fedsRoot[2]}, removed)
+	root := graph.Snapshot()
+	assert.Equal(t, 2, len(root.Children))
+	assert.True(t, root.Children[0].AgentStatus)
+	assert.Equal(t, "agent-1:19090", root.Children[0].AgentHost)
+	assert.Equal(t, len(fedsChild11), len(root.Children[0].Children))
+	// the nodes only federated by removed children are removed too
+	assert.False(t, graph.Search(fedsChild21[0], true))
+	assert.False(t, root.Children[1].LastUpdated.IsZero())
+}
+
+func TestReloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hercules")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "prometheus.yml")
+	writeReloadConfig(t, path, "'source-prometheus-1:9090'")
+
+	resolver, _ := NewAgentResolver(19090, "")
+	nodeRoot, _ := NewPrometheusNode(rootHost)
+	graph := NewGraph(nodeRoot)
+	reloader := NewReloader(graph, path, resolver)
+	assert.False(t, reloader.isModified())
+	assert.Nil(t, reloader.Reload())
+	assert.Equal(t, 1, len(graph.Snapshot().Children))
+
+	writeReloadConfig(t, path, "'source-prometheus-1:9090', 'source-prometheus-2:9090'")
+	assert.True(t, reloader.isModified())
+
+	handler := http.HandlerFunc(ReloadHandler(reloader))
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/-/reload", nil)
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusMethodNotAllowed, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/-/reload", nil)
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, 2, len(graph.Snapshot().Children))
+
+	os.Remove(path)
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.Equal(t, 2, len(graph.Snapshot().Children))
+}
+
+func TestReloaderRefreshServiceDiscovery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hercules")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "prometheus.yml")
+	sdPath := filepath.Join(dir, "targets.json")
+	config := `
+scrape_configs:
+- job_name: 'federate'
+  metrics_path: '/federate'
+  file_sd_configs:
+    - files: ['targets.json']
+      refresh_interval: 1m
+`
+	assert.Nil(t, ioutil.WriteFile(path, []byte(config), 0644))
+	assert.Nil(t, ioutil.WriteFile(sdPath, []byte(`[{"targets": ["source-prometheus-1:9090"]}]`), 0644))
+
+	resolver, _ := NewAgentResolver(19090, "")
+	nodeRoot, _ := NewPrometheusNode(rootHost)
+	graph := NewGraph(nodeRoot)
+	reloader := NewReloader(graph, path, resolver)
+	assert.Nil(t, reloader.Reload())
+	assert.False(t, reloader.needRefresh(time.Now()))
+	assert.True(t, reloader.needRefresh(time.Now().Add(time.Minute)))
+
+	// only the file_sd file is changed
+	assert.Nil(t, ioutil.WriteFile(sdPath, []byte(`[{"targets": ["source-prometheus-1:9090", "source-prometheus-2:9090"]}]`), 0644))
+	reloader.mutex.Lock()
+	reloader.lastLoad = time.Now().Add(-time.Minute)
+	reloader.mutex.Unlock()
+	quit := make(chan struct{})
+	defer close(quit)
+	go reloader.Watch(10*time.Millisecond, quit)
+	for i := 0; i < 100; i++ {
+		count := 0
+		graph.Read(func(root *PrometheusNode) {
+			count = len(root.Children)
+		})
+		if count == 2 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Expect file_sd targets refreshed, but got no change")
+}
+
+// newConfigAPIServer return a prometheus server whose config api
+// return the federation targets, and a function to change them
+func newConfigAPIServer(targets string) (*httptest.Server, func(string)) {
+	var mutex sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if targets == "" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "success",
+			"data":   map[string]string{"yaml": fmt.Sprintf(reloadConfig, targets)},
+		})
+	}))
+	return server, func(newTargets string) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		targets = newTargets
+	}
+}
+
+func TestAPIReloader(t *testing.T) {
+	server, setTargets := newConfigAPIServer("'source-prometheus-1:9090'")
+	defer server.Close()
+
+	resolver, _ := NewAgentResolver(19090, "")
+	nodeRoot, _ := NewPrometheusNode(rootHost)
+	graph := NewGraph(nodeRoot)
+	reloader := NewAPIReloader(graph, server.URL, time.Second, resolver)
+	assert.True(t, reloader.isModified())
+	assert.Nil(t, reloader.Reload())
+	assert.Equal(t, 1, len(graph.Snapshot().Children))
+
+	setTargets("'source-prometheus-1:9090', 'source-prometheus-2:9090'")
+	assert.Nil(t, reloader.Reload())
+	assert.Equal(t, 2, len(graph.Snapshot().Children))
+}
+
+func TestRetryReload(t *testing.T) {
+	server, setTargets := newConfigAPIServer("")
+	defer server.Close()
+	resolver, _ := NewAgentResolver(19090, "")
+	nodeRoot, _ := NewPrometheusNode(rootHost)
+	graph := NewGraph(nodeRoot)
+	reloader := NewAPIReloader(graph, server.URL, time.Second, resolver)
+
+	done := make(chan bool)
+	go func() {
+		done <- reloader.RetryReload(10*time.Millisecond, 20*time.Millisecond, nil)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	setTargets("'source-prometheus-1:9090'")
+	select {
+	case ok := <-done:
+		assert.True(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("Expect reload success after prometheus is ready")
+	}
+	assert.Equal(t, 1, len(graph.Snapshot().Children))
+
+	setTargets("")
+	quit := make(chan struct{})
+	close(quit)
+	assert.False(t, reloader.RetryReload(time.Minute, time.Minute, quit))
+}
@@ -0,0 +1,235 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	kitlog "github.com/go-kit/kit/log"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/discovery"
+	sd_config "github.com/prometheus/prometheus/discovery/config"
+	"github.com/prometheus/prometheus/discovery/dns"
+	"github.com/prometheus/prometheus/discovery/file"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	log "github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	dnsNameLabel  = model.MetaLabelPrefix + "dns_name"
+	fileSDLabel   = model.MetaLabelPrefix + "filepath"
+	dnsSDTypeSRV  = "SRV"
+	dnsSDTypeA    = "A"
+	dnsSDTypeAAAA = "AAAA"
+
+	// default refresh intervals same as prometheus, the discovery
+	// manager ones have no common setting so a minute is used
+	defaultFileSDRefresh  = 5 * time.Minute
+	defaultDNSSDRefresh   = 30 * time.Second
+	defaultManagerRefresh = time.Minute
+)
+
+// DNSResolver lookup the records for dns_sd_configs,
+// net.DefaultResolver implement this interface
+type DNSResolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+var (
+	// Resolver is used by dns_sd_configs, replace it for testing
+	Resolver DNSResolver = net.DefaultResolver
+	// DiscoveryTimeout is the max time waiting for dns lookup and
+	// prometheus discovery manager to return targets
+	DiscoveryTimeout = 10 * time.Second
+)
+
+// RefreshInterval return the shortest refresh interval of service
+// discovery used by federate jobs, the targets need to be resolved again
+// after it even if the config is not changed. 0 means only static targets
+func RefreshInterval(conf *config.Config) time.Duration {
+	var interval time.Duration
+	shorter := func(d time.Duration) {
+		if interval == 0 || d < interval {
+			interval = d
+		}
+	}
+	for _, sc := range conf.ScrapeConfigs {
+		if !mayFederate(sc) {
+			continue
+		}
+		sdc := sc.ServiceDiscoveryConfig
+		for _, fc := range sdc.FileSDConfigs {
+			shorter(refreshOrDefault(fc.RefreshInterval, defaultFileSDRefresh))
+		}
+		for _, dc := range sdc.DNSSDConfigs {
+			shorter(refreshOrDefault(dc.RefreshInterval, defaultDNSSDRefresh))
+		}
+		rest := sdc
+		rest.StaticConfigs = nil
+		rest.FileSDConfigs = nil
+		rest.DNSSDConfigs = nil
+		if !reflect.DeepEqual(rest, sd_config.ServiceDiscoveryConfig{}) {
+			shorter(defaultManagerRefresh)
+		}
+	}
+	return interval
+}
+
+func refreshOrDefault(d model.Duration, defaultInterval time.Duration) time.Duration {
+	if d <= 0 {
+		return defaultInterval
+	}
+	return time.Duration(d)
+}
+
+// GetTargetGroups return all target groups of scrape config, static_configs,
+// file_sd_configs and dns_sd_configs are resolved directly, other service
+// discovery mechanisms are resolved by prometheus discovery manager.
+// Relative file_sd path is based on baseDir
+func GetTargetGroups(sc *config.ScrapeConfig, baseDir string) []*targetgroup.Group {
+	sdc := sc.ServiceDiscoveryConfig
+	groups := append([]*targetgroup.Group{}, sdc.StaticConfigs...)
+	for _, fc := range sdc.FileSDConfigs {
+		groups = append(groups, fileTargetGroups(fc, baseDir)...)
+	}
+	for _, dc := range sdc.DNSSDConfigs {
+		groups = append(groups, dnsTargetGroups(dc)...)
+	}
+	rest := sdc
+	rest.StaticConfigs = nil
+	rest.FileSDConfigs = nil
+	rest.DNSSDConfigs = nil
+	if !reflect.DeepEqual(rest, sd_config.ServiceDiscoveryConfig{}) {
+		groups = append(groups, managerTargetGroups(sc.JobName, rest)...)
+	}
+	return groups
+}
+
+func fileTargetGroups(fc *file.SDConfig, baseDir string) []*targetgroup.Group {
+	groups := []*targetgroup.Group{}
+	for _, pattern := range fc.Files {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(baseDir, pattern)
+		}
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			log.Warnf("Invalid file_sd pattern %s: %s", pattern, err)
+			continue
+		}
+		for _, f := range files {
+			tgs, err := readTargetGroupsFile(f)
+			if err != nil {
+				log.Warnf("Read file_sd file %s fail: %s", f, err)
+				continue
+			}
+			for index, tg := range tgs {
+				if tg.Labels == nil {
+					tg.Labels = model.LabelSet{}
+				}
+				tg.Labels[fileSDLabel] = model.LabelValue(f)
+				tg.Source = fmt.Sprintf("%s:%d", f, index)
+			}
+			groups = append(groups, tgs...)
+		}
+	}
+	return groups
+}
+
+func readTargetGroupsFile(path string) ([]*targetgroup.Group, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tgs []*targetgroup.Group
+	switch ext := filepath.Ext(path); strings.ToLower(ext) {
+	case ".json":
+		err = json.Unmarshal(content, &tgs)
+	case ".yml", ".yaml":
+		err = yaml.Unmarshal(content, &tgs)
+	default:
+		err = fmt.Errorf("unsupported file extension %s", ext)
+	}
+	return tgs, err
+}
+
+func dnsTargetGroups(dc *dns.SDConfig) []*targetgroup.Group {
+	groups := []*targetgroup.Group{}
+	for _, name := range dc.Names {
+		ctx, cancel := context.WithTimeout(context.Background(), DiscoveryTimeout)
+		targets, err := lookupDNSTargets(ctx, name, dc.Type, dc.Port)
+		cancel()
+		if err != nil {
+			log.Warnf("Lookup dns_sd name %s fail: %s", name, err)
+			continue
+		}
+		groups = append(groups, &targetgroup.Group{
+			Targets: targets,
+			Source:  name,
+		})
+	}
+	return groups
+}
+
+func lookupDNSTargets(ctx context.Context, name string, qtype string, port int) ([]model.LabelSet, error) {
+	targets := []model.LabelSet{}
+	addTarget := func(host string, port int) {
+		targets = append(targets, model.LabelSet{
+			model.AddressLabel: model.LabelValue(net.JoinHostPort(host, strconv.Itoa(port))),
+			dnsNameLabel:       model.LabelValue(name),
+		})
+	}
+	switch qtype {
+	case dnsSDTypeSRV, "":
+		_, records, err := Resolver.LookupSRV(ctx, "", "", name)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			addTarget(strings.TrimRight(record.Target, "."), int(record.Port))
+		}
+	case dnsSDTypeA, dnsSDTypeAAAA:
+		addrs, err := Resolver.LookupIPAddr(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		for _, addr := range addrs {
+			isIPv4 := addr.IP.To4() != nil
+			if (qtype == dnsSDTypeA) == isIPv4 {
+				addTarget(addr.IP.String(), port)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported dns query type %s", qtype)
+	}
+	return targets, nil
+}
+
+// managerTargetGroups run prometheus discovery manager and wait for
+// the first target groups it found
+func managerTargetGroups(name string, sdc sd_config.ServiceDiscoveryConfig) []*targetgroup.Group {
+	ctx, cancel := context.WithTimeout(context.Background(), DiscoveryTimeout)
+	defer cancel()
+	manager := discovery.NewManager(ctx, kitlog.NewNopLogger())
+	go manager.Run()
+	if err := manager.ApplyConfig(map[string]sd_config.ServiceDiscoveryConfig{name: sdc}); err != nil {
+		log.Warnf("Apply service discovery config of %s fail: %s", name, err)
+		return nil
+	}
+	select {
+	case tgs := <-manager.SyncCh():
+		return tgs[name]
+	case <-ctx.Done():
+		log.Warnf("Service discovery of %s timeout", name)
+		return nil
+	}
+}
@@ -0,0 +1,83 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/config"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeResolver struct{}
+
+func (fakeResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if name != "_prometheus._tcp.example.com" {
+		return "", nil, errors.New("no such host")
+	}
+	return "", []*net.SRV{
+		{Target: "dns-prometheus-1.example.com.", Port: 9090},
+		{Target: "dns-prometheus-2.example.com.", Port: 9091},
+	}, nil
+}
+
+func (fakeResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if host != "prometheus.example.com" {
+		return nil, errors.New("no such host")
+	}
+	return []net.IPAddr{
+		{IP: net.ParseIP("10.0.0.1")},
+		{IP: net.ParseIP("2001:db8::1")},
+	}, nil
+}
+
+func TestGetFederationTargetsWithServiceDiscovery(t *testing.T) {
+	defaultResolver := Resolver
+	Resolver = fakeResolver{}
+	defer func() { Resolver = defaultResolver }()
+
+	targets, err := GetFederationTargetsFromConfig("testdata/prometheus.sd.conf")
+	assert.Nil(t, err)
+	hosts := map[string]string{}
+	for _, target := range targets {
+		hosts[target.PrometheusHost] = target.AgentHost
+	}
+	assert.Equal(t, map[string]string{
+		"region-prometheus-1:9090":          "",
+		"region-prometheus-2:9090":          "",
+		"region-prometheus-3:9090":          "region-agent-3:19090",
+		"dns-prometheus-1.example.com:9090": "",
+		"dns-prometheus-2.example.com:9091": "",
+		"10.0.0.1:9090":                     "",
+	}, hosts)
+}
+
+func TestRefreshInterval(t *testing.T) {
+	conf, err := config.LoadFile("testdata/prometheus.sd.conf")
+	assert.Nil(t, err)
+	// dns_sd default is shorter than file_sd one
+	assert.Equal(t, 30*time.Second, RefreshInterval(conf))
+
+	conf, err = config.Load(fmt.Sprintf(reloadConfig, "'source-prometheus-1:9090'"))
+	assert.Nil(t, err)
+	assert.Equal(t, time.Duration(0), RefreshInterval(conf))
+}
+
+func TestLookupDNSTargets(t *testing.T) {
+	defaultResolver := Resolver
+	Resolver = fakeResolver{}
+	defer func() { Resolver = defaultResolver }()
+
+	targets, err := lookupDNSTargets(context.Background(), "prometheus.example.com", "AAAA", 9090)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(targets))
+	assert.Equal(t, "[2001:db8::1]:9090", string(targets[0]["__address__"]))
+
+	_, err = lookupDNSTargets(context.Background(), "prometheus.example.com", "MX", 9090)
+	assert.NotNil(t, err)
+	_, err = lookupDNSTargets(context.Background(), "not-exist.example.com", "SRV", 0)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// SearchResult is the paths from root to every node matched the host
+type SearchResult struct {
+	Host  string     `json:"host"`
+	Found bool       `json:"found"`
+	Paths [][]string `json:"paths"`
+}
+
+// SearchPaths return the paths from current node to every node
+// matched the host, including current node itself
+func (pn *PrometheusNode) SearchPaths(host string) [][]string {
+	paths := [][]string{}
+	if pn.PrometheusHost == host {
+		paths = append(paths, []string{host})
+	}
+	if pn.Search(host, true) == false {
+		return paths
+	}
+	for _, ancestors := range pn.ancestorsOf(host) {
+		paths = append(paths, append(append([]string{}, ancestors...), host))
+	}
+	return paths
+}
+
+// SearchGraph will return a http handler function which
+// find the host in graph and return the paths to it
+func SearchGraph(g *Graph) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.URL.Query().Get("host")
+		if host == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("Host is required"))
+			return
+		}
+		result := SearchResult{Host: host}
+		g.Read(func(root *PrometheusNode) {
+			result.Paths = root.SearchPaths(host)
+		})
+		result.Found = len(result.Paths) > 0
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(result)
+	}
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchPaths(t *testing.T) {
+	node := newHATree()
+	assert.Equal(t, [][]string{{node.PrometheusHost}}, node.SearchPaths(node.PrometheusHost))
+	paths := node.SearchPaths(regionalHosts[0])
+	assert.Equal(t, 2, len(paths))
+	for index, path := range paths {
+		assert.Equal(t, []string{node.PrometheusHost, globalHosts[index], regionalHosts[0]}, path)
+	}
+	assert.Equal(t, [][]string{}, node.SearchPaths("unknown:9090"))
+}
+
+func TestSearchGraph(t *testing.T) {
+	handler := http.HandlerFunc(SearchGraph(NewGraph(newHATree())))
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/graph/search?host="+regionalHosts[1], nil)
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	var result SearchResult
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+	assert.True(t, result.Found)
+	assert.Equal(t, 2, len(result.Paths))
+
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/graph/search?host=unknown:9090", nil)
+	handler.ServeHTTP(recorder, req)
+	result = SearchResult{}
+	assert.Nil(t, json.Unmarshal(recorder.Body.Bytes(), &result))
+	assert.False(t, result.Found)
+
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/graph/search", nil)
+	handler.ServeHTTP(recorder, req)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// LastActive return the latest time of last updated and last seen,
+// zero time means the node has never been updated or seen
+func (pn *PrometheusNode) LastActive() time.Time {
+	return latest(pn.LastUpdated, pn.LastSeen)
+}
+
+// isExpired return true if node is not active for more than ttl
+func (pn *PrometheusNode) isExpired(now time.Time, ttl time.Duration) bool {
+	lastActive := pn.LastActive()
+	return !lastActive.IsZero() && now.Sub(lastActive) > ttl
+}
+
+// touchInserted set last updated of the nodes in subtree which have
+// never been updated or seen, so a node inserted and never seen again
+// still expires after ttl
+func (pn *PrometheusNode) touchInserted(now time.Time) {
+	if pn.LastActive().IsZero() {
+		pn.LastUpdated = now
+	}
+	for _, child := range pn.Children {
+		child.touchInserted(now)
+	}
+}
+
+// markStale mark the nodes which are not active for more than ttl as
+// stale, a node is fresh only if it can be reached from root through
+// fresh nodes, so the nodes only federated by stale parents are stale
+// too. Root itself is never stale
+func (g *Graph) markStale(now time.Time, ttl time.Duration) {
+	children := map[string][]string{}
+	for _, edge := range g.edges {
+		children[edge.Parent] = append(children[edge.Parent], edge.Child)
+	}
+	fresh := map[string]bool{g.root: true}
+	queue := []string{g.root}
+	for len(queue) > 0 {
+		host := queue[0]
+		queue = queue[1:]
+		for _, child := range children[host] {
+			if fresh[child] == false && !g.nodes[child].isExpired(now, ttl) {
+				fresh[child] = true
+				queue = append(queue, child)
+			}
+		}
+	}
+	for host, node := range g.nodes {
+		node.Stale = !fresh[host]
+	}
+}
+
+// prune mark stale nodes and remove the nodes stale for more than
+// removeAfter. The first level children come from prometheus config so
+// only their edges to children are removed, then the nodes can't be
+// reached from root any more are removed. Return the hosts removed
+func (g *Graph) prune(now time.Time, ttl time.Duration, removeAfter time.Duration) []string {
+	g.markStale(now, ttl)
+	removed := []string{}
+	for host, node := range g.nodes {
+		if host == g.root || !node.isExpired(now, ttl+removeAfter) {
+			continue
+		}
+		if g.hasEdge(g.root, host) {
+			g.edges = replaceEdges(g.edges, host, nil)
+			continue
+		}
+		g.deleteNode(host)
+		removed = append(removed, host)
+	}
+	removed = append(removed, g.removeUnreachable()...)
+	sort.Strings(removed)
+	return removed
+}
+
+// Prune mark stale nodes and remove the expired nodes of graph
+func (g *Graph) Prune(ttl time.Duration, removeAfter time.Duration) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	removed := g.prune(time.Now(), ttl, removeAfter)
+	if len(removed) > 0 {
+		log.Infof("Remove stale nodes %v", removed)
+	}
+}
@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newStaleTree(now time.Time) *PrometheusNode {
+	nodeRoot, _ := NewPrometheusNode(rootHost)
+	nodeRoot.Children = NewPrometheusNodeList(fedsRoot)
+	// source-prometheus-1 is active, but its child 11 is not
+	nodeRoot.Children[0].LastSeen = now
+	nodeRoot.Children[0].Children = NewPrometheusNodeList(fedsChild11)
+	nodeRoot.Children[0].Children[0].LastUpdated = now.Add(-time.Hour)
+	nodeRoot.Children[0].Children[0].Children = NewPrometheusNodeList(fedsChild111)
+	nodeRoot.Children[0].Children[1].LastUpdated = now.Add(-2 * time.Minute)
+	// source-prometheus-2 is not active
+	nodeRoot.Children[1].LastUpdated = now.Add(-time.Hour)
+	nodeRoot.Children[1].Children = NewPrometheusNodeList(fedsChild21)
+	return nodeRoot
+}
+
+func TestPrometheusNodeLastActive(t *testing.T) {
+	now := time.Now()
+	node, _ := NewPrometheusNode(rootHost)
+	assert.True(t, node.LastActive().IsZero())
+	node.LastUpdated = now.Add(-time.Minute)
+	assert.Equal(t, now.Add(-time.Minute), node.LastActive())
+	node.LastSeen = now
+	assert.Equal(t, now, node.LastActive())
+}
+
+func TestGraphMarkStale(t *testing.T) {
+	now := time.Now()
+	graph := NewGraph(newStaleTree(now))
+	graph.markStale(now, 5*time.Minute)
+
+	nodeRoot := graph.Snapshot()
+	assert.False(t, nodeRoot.Stale)
+	assert.False(t, nodeRoot.Children[0].Stale)
+	assert.True(t, nodeRoot.Children[0].Children[0].Stale)
+	assert.True(t, nodeRoot.Children[0].Children[0].Children[0].Stale)
+	assert.False(t, nodeRoot.Children[0].Children[1].Stale)
+	assert.False(t, nodeRoot.Children[0].Children[2].Stale)
+	assert.True(t, nodeRoot.Children[1].Stale)
+	assert.True(t, nodeRoot.Children[1].Children[0].Stale)
+	assert.False(t, nodeRoot.Children[2].Stale)
+
+	graph.Update(func(root *PrometheusNode) {
+		root.Children[1].LastSeen = now
+	})
+	graph.markStale(now, 5*time.Minute)
+	nodeRoot = graph.Snapshot()
+	assert.False(t, nodeRoot.Children[1].Stale)
+	assert.False(t, nodeRoot.Children[1].Children[0].Stale)
+}
+
+func TestGraphMarkStaleSharedNode(t *testing.T) {
+	now := time.Now()
+	shared := "shared-prometheus:9090"
+	nodeRoot, _ := NewPrometheusNode(rootHost)
+	nodeRoot.Children = NewPrometheusNodeList(fedsRoot[:2])
+	nodeRoot.Children[0].LastUpdated = now.Add(-time.Hour)
+	nodeRoot.Children[0].Children = NewPrometheusNodeList([]string{shared})
+	nodeRoot.Children[1].LastSeen = now
+	nodeRoot.Children[1].Children = NewPrometheusNodeList([]string{shared})
+	nodeRoot.Children[1].Children[0].LastUpdated = now
+	graph := NewGraph(nodeRoot)
+
+	// still fresh through the active parent
+	graph.markStale(now, 5*time.Minute)
+	nodeRoot = graph.Snapshot()
+	assert.True(t, nodeRoot.Children[0].Stale)
+	assert.False(t, nodeRoot.Children[0].Children[0].Stale)
+	assert.False(t, nodeRoot.Children[1].Children[0].Stale)
+}
+
+func TestGraphPrune(t *testing.T) {
+	now := time.Now()
+	graph := NewGraph(newStaleTree(now))
+	removed := graph.prune(now, 5*time.Minute, 10*time.Minute)
+	assert.ElementsMatch(t, append(append([]string{fedsChild11[0]}, fedsChild111...), fedsChild21...), removed)
+
+	nodeRoot := graph.Snapshot()
+	assert.Equal(t, len(fedsRoot), len(nodeRoot.Children))
+	assert.False(t, graph.Search(fedsChild11[0], true))
+	assert.False(t, graph.Search(fedsChild111[0], true))
+	assert.True(t, graph.Search(fedsChild11[1], true))
+	assert.Equal(t, 0, len(nodeRoot.Children[1].Children))
+	assert.True(t, nodeRoot.Children[1].Stale)
+}
+
+func TestGraphPruneSharedHost(t *testing.T) {
+	now := time.Now()
+	shared := "shared-prometheus:9090"
+	nodeRoot, _ := NewPrometheusNode(rootHost)
+	nodeRoot.Children = NewPrometheusNodeList(fedsRoot[:2])
+	nodeRoot.Children[0].LastSeen = now
+	nodeRoot.Children[0].Children = NewPrometheusNodeList([]string{shared})
+	nodeRoot.Children[0].Children[0].LastUpdated = now.Add(-time.Hour)
+	nodeRoot.Children[1].LastSeen = now
+	nodeRoot.Children[1].Children = NewPrometheusNodeList([]string{shared})
+	nodeRoot.Children[1].Children[0].LastUpdated = now
+	graph := NewGraph(nodeRoot)
+
+	// the shared host is one node which is active through the latest copy
+	removed := graph.prune(now, 5*time.Minute, 10*time.Minute)
+	assert.Equal(t, []string{}, removed)
+	nodeRoot = graph.Snapshot()
+	if len(nodeRoot.Children[0].Children) != 1 || len(nodeRoot.Children[1].Children) != 1 {
+		t.Fatalf("Expect %s kept under both parents, but got %v", shared, nodeRoot.Children)
+	}
+	assert.False(t, nodeRoot.Children[0].Children[0].Stale)
+
+	// expired once, removed from every parent
+	removed = graph.prune(now.Add(time.Hour), 5*time.Minute, 10*time.Minute)
+	assert.Equal(t, []string{shared}, removed)
+	assert.False(t, graph.Search(shared, true))
+}
+
+func TestPrintNodesTreeWithStale(t *testing.T) {
+	now := time.Now()
+	nodeRoot := newStaleTree(now)
+	nodeRoot.Children[0].Children = nil
+	nodeRoot.Children[1].Children = nil
+	graph := NewGraph(nodeRoot)
+	graph.markStale(now, 5*time.Minute)
+	expect := `
+source-prometheus:9090[agent=error][prometheus=error]
+--source-prometheus-1:9090[agent=error][prometheus=error]
+--source-prometheus-2:9090[agent=error][prometheus=error][stale]
+--source-prometheus-3:9090[agent=error][prometheus=error]`
+	assert.Equal(t, expect, graph.PrintNodesTree("--", 0, true))
+}
+
+func TestGraphInsertOrUpdateTimestamp(t *testing.T) {
+	nodeRoot, _ := NewPrometheusNode(rootHost)
+	nodeRoot.Children = NewPrometheusNodeList(fedsRoot)
+	graph := NewGraph(nodeRoot)
+	before := time.Now()
+
+	node, _ := NewPrometheusNode(fedsRoot[0])
+	graph.InsertOrUpdate(node, true)
+	node, _ = NewPrometheusNode("new-prometheus:9090")
+	graph.InsertOrUpdate(node, true)
+	graph.Read(func(root *PrometheusNode) {
+		assert.False(t, root.Children[0].LastUpdated.Before(before))
+		assert.False(t, root.Children[3].LastUpdated.Before(before))
+		assert.True(t, root.Children[1].LastUpdated.IsZero())
+	})
+}
+
+func TestGraphInsertedNodeExpired(t *testing.T) {
+	nodeRoot, _ := NewPrometheusNode(rootHost)
+	nodeRoot.Children = NewPrometheusNodeList(fedsRoot)
+	graph := NewGraph(nodeRoot)
+
+	// pushed children never seen again should expire after ttl
+	node, _ := NewPrometheusNode(fedsRoot[0])
+	node.Children = NewPrometheusNodeList(fedsChild11)
+	node.Children[0].Children = NewPrometheusNodeList(fedsChild111)
+	graph.InsertOrUpdate(node, true)
+	later := time.Now().Add(10 * time.Minute)
+	graph.markStale(later, 5*time.Minute)
+	graph.Read(func(root *PrometheusNode) {
+		assert.True(t, root.Children[0].Children[0].Stale)
+		assert.True(t, root.Children[0].Children[0].Children[0].Stale)
+		assert.False(t, root.Children[0].Children[0].LastUpdated.IsZero())
+	})
+}
@@ -0,0 +1,469 @@
+package utils
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Graph is a concurrency safe store of the federation graph, nodes
+// are keyed by host and the federation edges are stored separately,
+// so a host federated by many parents is one node and its status is
+// updated once. The node tree is only a view built from root following
+// the edges, all reads and writes should go through the graph
+type Graph struct {
+	mutex sync.RWMutex
+	root  string
+	// nodes never have children or federation, they are in edges
+	nodes    map[string]*PrometheusNode
+	edges    []TopologyEdge
+	cycles   []CycleReport
+	resolver *AgentResolver
+}
+
+// NewGraph create a graph store from the root node and its tree
+func NewGraph(root *PrometheusNode) *Graph {
+	g := &Graph{}
+	g.load(root)
+	return g
+}
+
+// load replace the whole graph by the tree
+func (g *Graph) load(root *PrometheusNode) {
+	g.root = root.PrometheusHost
+	g.nodes = map[string]*PrometheusNode{g.root: storedNode(root)}
+	g.edges = subtreeEdges(nil, root)
+	g.mergeNodes(root, time.Time{})
+}
+
+// storedNode return a copy of node without children and federation
+func storedNode(pn *PrometheusNode) *PrometheusNode {
+	node := *pn
+	node.Children = nil
+	node.Federation = nil
+	return &node
+}
+
+// subtreeEdges replace the edges from every node in the subtree of pn
+// by the edges to its children, a host appears many times is replaced once
+func subtreeEdges(edges []TopologyEdge, pn *PrometheusNode) []TopologyEdge {
+	replaced := map[string]bool{}
+	var walk func(node *PrometheusNode)
+	walk = func(node *PrometheusNode) {
+		if replaced[node.PrometheusHost] == true {
+			return
+		}
+		replaced[node.PrometheusHost] = true
+		children := []TopologyEdge{}
+		for _, child := range node.Children {
+			children = append(children, TopologyEdge{
+				Parent:     node.PrometheusHost,
+				Child:      child.PrometheusHost,
+				Federation: cloneFederation(child.Federation),
+			})
+		}
+		edges = replaceEdges(edges, node.PrometheusHost, children)
+		for _, child := range node.Children {
+			walk(child)
+		}
+	}
+	walk(pn)
+	return edges
+}
+
+// replaceEdges return the edges with the ones from parent replaced
+func replaceEdges(edges []TopologyEdge, parent string, children []TopologyEdge) []TopologyEdge {
+	newEdges := []TopologyEdge{}
+	for _, edge := range edges {
+		if edge.Parent != parent {
+			newEdges = append(newEdges, edge)
+		}
+	}
+	seen := map[string]bool{}
+	for _, edge := range children {
+		if seen[edge.Child] == false {
+			seen[edge.Child] = true
+			newEdges = append(newEdges, edge)
+		}
+	}
+	return newEdges
+}
+
+func cloneFederation(federation *FederationEdge) *FederationEdge {
+	if federation == nil {
+		return nil
+	}
+	edge := *federation
+	edge.Match = append([]string(nil), federation.Match...)
+	return &edge
+}
+
+// mergeNodes store every node under pn, new nodes are added as they are
+// and the ones never active are set updated at now. Exist nodes take the
+// status only if the new one is active later, and nodes reported without
+// being active are kept alive by now, like a tree copy inserted again
+func (g *Graph) mergeNodes(pn *PrometheusNode, now time.Time) {
+	for _, child := range pn.Children {
+		g.mergeNode(child, now)
+		g.mergeNodes(child, now)
+	}
+}
+
+func (g *Graph) mergeNode(pn *PrometheusNode, now time.Time) {
+	node, ok := g.nodes[pn.PrometheusHost]
+	if !ok {
+		node = storedNode(pn)
+		if node.LastActive().IsZero() {
+			node.LastUpdated = now
+		}
+		g.nodes[pn.PrometheusHost] = node
+		return
+	}
+	if node.AgentHost == "" {
+		node.AgentHost = pn.AgentHost
+	}
+	lastActive := pn.LastActive()
+	if lastActive.IsZero() {
+		node.LastUpdated = latest(node.LastUpdated, now)
+		return
+	}
+	if !lastActive.Before(node.LastActive()) {
+		node.AgentStatus = pn.AgentStatus
+		node.PrometheusStatus = pn.PrometheusStatus
+		node.Latency = pn.Latency
+		node.LastUpdated = latest(node.LastUpdated, pn.LastUpdated)
+		node.LastSeen = latest(node.LastSeen, pn.LastSeen)
+	}
+}
+
+// hasEdge return true if parent federate child
+func (g *Graph) hasEdge(parent string, child string) bool {
+	for _, edge := range g.edges {
+		if edge.Parent == parent && edge.Child == child {
+			return true
+		}
+	}
+	return false
+}
+
+// childEdges return the edges from parent in order
+func (g *Graph) childEdges(parent string) []TopologyEdge {
+	edges := []TopologyEdge{}
+	for _, edge := range g.edges {
+		if edge.Parent == parent {
+			edges = append(edges, edge)
+		}
+	}
+	return edges
+}
+
+// removeUnreachable delete the nodes and edges which can't be reached
+// from root any more, return the sorted hosts removed
+func (g *Graph) removeUnreachable() []string {
+	children := map[string][]string{}
+	for _, edge := range g.edges {
+		children[edge.Parent] = append(children[edge.Parent], edge.Child)
+	}
+	visited := map[string]bool{}
+	queue := []string{g.root}
+	for len(queue) > 0 {
+		host := queue[0]
+		queue = queue[1:]
+		if visited[host] == true {
+			continue
+		}
+		visited[host] = true
+		queue = append(queue, children[host]...)
+	}
+	edges := []TopologyEdge{}
+	for _, edge := range g.edges {
+		if visited[edge.Parent] == true {
+			edges = append(edges, edge)
+		}
+	}
+	g.edges = edges
+	removed := []string{}
+	for host := range g.nodes {
+		if visited[host] == false {
+			delete(g.nodes, host)
+			removed = append(removed, host)
+		}
+	}
+	sort.Strings(removed)
+	return removed
+}
+
+// tree build the node tree from root, a host federated by many parents
+// appears under each of them with the same status
+func (g *Graph) tree() *PrometheusNode {
+	children := map[string][]TopologyEdge{}
+	for _, edge := range g.edges {
+		children[edge.Parent] = append(children[edge.Parent], edge)
+	}
+	var build func(host string, federation *FederationEdge, path map[string]bool) *PrometheusNode
+	build = func(host string, federation *FederationEdge, path map[string]bool) *PrometheusNode {
+		node := *g.nodes[host]
+		node.Federation = cloneFederation(federation)
+		path[host] = true
+		for _, edge := range children[host] {
+			// cycles are refused on update, but never loop here
+			if path[edge.Child] == true {
+				continue
+			}
+			node.Children = append(node.Children, build(edge.Child, edge.Federation, path))
+		}
+		delete(path, host)
+		return &node
+	}
+	return build(g.root, nil, map[string]bool{})
+}
+
+// SetAgentResolver set the resolver used to fill the agent host
+// of nodes pushed, discovered or restored into the graph
+func (g *Graph) SetAgentResolver(resolver *AgentResolver) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.resolver = resolver
+}
+
+// Snapshot return the tree view of graph
+func (g *Graph) Snapshot() *PrometheusNode {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return g.tree()
+}
+
+// Read call fn with the tree view under read lock,
+// changes on the tree are not written back
+func (g *Graph) Read(fn func(root *PrometheusNode)) {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	fn(g.tree())
+}
+
+// Update call fn with the tree view under write lock,
+// and replace the graph by the changed tree
+func (g *Graph) Update(fn func(root *PrometheusNode)) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	root := g.tree()
+	fn(root)
+	g.load(root)
+}
+
+// Search will find the host and return true if exist,
+// only the children of root are checked if not recursive
+func (g *Graph) Search(host string, recursive bool) bool {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return g.search(host, recursive)
+}
+
+func (g *Graph) search(host string, recursive bool) bool {
+	if recursive == false {
+		return g.hasEdge(g.root, host)
+	}
+	_, ok := g.nodes[host]
+	return ok && host != g.root
+}
+
+// InsertOrUpdate will store the new node and its subtree, the edges
+// from every node in the subtree are replaced by the new ones. If search
+// and the node is not a child of root, it is added under root, otherwise
+// only the node already in graph is updated. Return error and keep the
+// graph unchanged if the new node would make a federation cycle
+func (g *Graph) InsertOrUpdate(newNode *PrometheusNode, search bool) error {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.resolver.ResolveTree(newNode)
+	host := newNode.PrometheusHost
+	attach := search && g.hasEdge(g.root, host) == false
+	if _, ok := g.nodes[host]; !attach && (!ok || host == g.root) {
+		return nil
+	}
+	edges := subtreeEdges(g.edges, newNode)
+	// the children of root only come from config of current agent
+	edges = replaceEdges(edges, g.root, g.childEdges(g.root))
+	if attach {
+		edges = append(edges, TopologyEdge{
+			Parent:     g.root,
+			Child:      host,
+			Federation: cloneFederation(newNode.Federation),
+		})
+	}
+	if cycles := g.topology(edges).FindCycles(); len(cycles) > 0 {
+		g.recordCycle(cycles[0])
+		return &CycleError{Cycle: cycles[0]}
+	}
+	now := time.Now()
+	g.edges = edges
+	if node, ok := g.nodes[host]; ok {
+		node.LastUpdated = now
+	} else {
+		g.nodes[host] = storedNode(newNode)
+		g.nodes[host].LastUpdated = now
+	}
+	g.mergeNodes(newNode, now)
+	g.removeUnreachable()
+	return nil
+}
+
+// DeleteNodeByHost will delete the node and its edges from graph,
+// the nodes only reachable through it are removed too
+func (g *Graph) DeleteNodeByHost(host string) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if _, ok := g.nodes[host]; !ok || host == g.root {
+		return false
+	}
+	g.deleteNode(host)
+	g.removeUnreachable()
+	return true
+}
+
+func (g *Graph) deleteNode(host string) {
+	delete(g.nodes, host)
+	edges := []TopologyEdge{}
+	for _, edge := range g.edges {
+		if edge.Parent != host && edge.Child != host {
+			edges = append(edges, edge)
+		}
+	}
+	g.edges = edges
+}
+
+// SearchAndUpdateAgentStatus will find the node and update agent status
+func (g *Graph) SearchAndUpdateAgentStatus(host string, recursive bool, status bool) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.search(host, recursive) == false {
+		return false
+	}
+	g.nodes[host].AgentStatus = status
+	return true
+}
+
+// SearchAndUpdatePrometheusStatus will find the node and update prometheus status
+func (g *Graph) SearchAndUpdatePrometheusStatus(host string, recursive bool, status bool) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if g.search(host, recursive) == false {
+		return false
+	}
+	g.nodes[host].PrometheusStatus = status
+	return true
+}
+
+// UpdateChildren replace the children of root by the new list, exist
+// nodes keep their status and edges, only agent host and federation
+// edge are updated. Return the hosts added and removed under root
+func (g *Graph) UpdateChildren(children PrometheusNodeList) (added []string, removed []string) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	now := time.Now()
+	exist := map[string]bool{}
+	for _, edge := range g.childEdges(g.root) {
+		exist[edge.Child] = true
+	}
+	edges := []TopologyEdge{}
+	for _, child := range children {
+		if node, ok := g.nodes[child.PrometheusHost]; ok {
+			node.AgentHost = child.AgentHost
+		} else {
+			g.mergeNode(child, now)
+			g.mergeNodes(child, now)
+			g.edges = subtreeEdges(g.edges, child)
+		}
+		if exist[child.PrometheusHost] == false {
+			added = append(added, child.PrometheusHost)
+		}
+		delete(exist, child.PrometheusHost)
+		edges = append(edges, TopologyEdge{
+			Parent:     g.root,
+			Child:      child.PrometheusHost,
+			Federation: cloneFederation(child.Federation),
+		})
+	}
+	for _, edge := range g.childEdges(g.root) {
+		if exist[edge.Child] == true {
+			removed = append(removed, edge.Child)
+		}
+	}
+	g.edges = replaceEdges(g.edges, g.root, edges)
+	g.removeUnreachable()
+	return added, removed
+}
+
+// PrintNodesTree print out the tree view of graph
+func (g *Graph) PrintNodesTree(prefix string, depth int, withStatus bool) string {
+	g.mutex.RLock()
+	defer g.mutex.RUnlock()
+	return g.tree().PrintNodesTree(prefix, depth, withStatus)
+}
+
+// Ping check the status of root and its children, the lock is
+// not held while sending requests
+func (g *Graph) Ping(timeout time.Duration) {
+	g.mutex.RLock()
+	self := g.root
+	targets := []pingTarget{}
+	for _, edge := range g.childEdges(g.root) {
+		child := *g.nodes[edge.Child]
+		child.Federation = edge.Federation
+		targets = append(targets, pingTarget{host: child.PrometheusHost, scheme: child.Scheme(), agentHost: child.GetAgentHost()})
+	}
+	g.mutex.RUnlock()
+
+	results := ping(self, targets, timeout)
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	now := time.Now()
+	// current agent is running, so always alive
+	root := g.nodes[g.root]
+	root.AgentStatus = true
+	root.LastSeen = now
+	for _, result := range results {
+		// a host federated by many parents is updated once
+		if node, ok := g.nodes[result.host]; ok {
+			node.applyPingResult(result, now)
+		}
+	}
+}
+
+// Discover pull the graph from children agents without holding the
+// lock, then merge only the children fetched successfully in this round,
+// so the updates landed during the crawl are kept
+func (g *Graph) Discover(maxDepth int, timeout time.Duration) {
+	if maxDepth <= 0 {
+		return
+	}
+	g.mutex.RLock()
+	root := g.tree()
+	resolver := g.resolver
+	g.mutex.RUnlock()
+
+	path := map[string]bool{root.PrometheusHost: true}
+	fetched := fetchChildren(root.Children, timeout, resolver, path)
+	var wg sync.WaitGroup
+	for _, node := range fetched {
+		wg.Add(1)
+		go func(node *PrometheusNode) {
+			defer wg.Done()
+			nodePath := copyPath(path)
+			nodePath[node.PrometheusHost] = true
+			node.discover(maxDepth-1, timeout, resolver, nodePath)
+		}(node)
+	}
+	wg.Wait()
+
+	for _, node := range fetched {
+		// not search in first layer, children removed during
+		// the crawl should not be added back
+		if err := g.InsertOrUpdate(node, false); err != nil {
+			log.Warnf("Merge graph of %s fail: %s", node.PrometheusHost, err)
+		}
+	}
+}
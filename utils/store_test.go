@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusNodeClone(t *testing.T) {
+	nodeRoot, _ := NewPrometheusNode(rootHost)
+	nodeRoot.Children = NewPrometheusNodeList(fedsRoot)
+	nodeRoot.Children[0].Federation = &FederationEdge{JobName: "federate", Match: []string{`{job="prometheus"}`}}
+	nodeRoot.Children[0].Children = NewPrometheusNodeList(fedsChild11)
+
+	clone := nodeRoot.Clone()
+	assert.Equal(t, nodeRoot, clone)
+	clone.Children[0].Children[0].AgentStatus = true
+	clone.Children[0].Federation.Match[0] = "{}"
+	clone.Children = clone.Children[:1]
+	assert.False(t, nodeRoot.Children[0].Children[0].AgentStatus)
+	assert.Equal(t, `{job="prometheus"}`, nodeRoot.Children[0].Federation.Match[0])
+	assert.Equal(t, len(fedsRoot), len(nodeRoot.Children))
+}
+
+func TestGraphSnapshotIsolation(t *testing.T) {
+	nodeRoot, _ := NewPrometheusNode(rootHost)
+	nodeRoot.Children = NewPrometheusNodeList(fedsRoot)
+	graph := NewGraph(nodeRoot)
+
+	snapshot := graph.Snapshot()
+	graph.DeleteNodeByHost(fedsRoot[0])
+	assert.Equal(t, len(fedsRoot), len(snapshot.Children))
+	assert.False(t, graph.Search(fedsRoot[0], true))
+	assert.True(t, graph.SearchAndUpdateAgentStatus(fedsRoot[1], false, true))
+	assert.True(t, graph.SearchAndUpdatePrometheusStatus(fedsRoot[1], false, true))
+	graph.Read(func(root *PrometheusNode) {
+		assert.True(t, root.Children[0].AgentStatus)
+		assert.True(t, root.Children[0].PrometheusStatus)
+	})
+}
+
+func TestGraphConcurrentAccess(t *testing.T) {
+	server := newPrometheusServer(true)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	nodeRoot, _ := NewPrometheusNode(host)
+	nodeRoot.Children = NewPrometheusNodeList(fedsRoot)
+	for _, child := range nodeRoot.Children {
+		child.AgentHost = host
+	}
+	graph := NewGraph(nodeRoot)
+	getHandler := http.HandlerFunc(GetGraph(graph))
+	updateHandler := http.HandlerFunc(UpdateGraph(graph))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(6)
+		go func(i int) {
+			defer wg.Done()
+			node, _ := NewPrometheusNode(fmt.Sprintf("new-prometheus-%d:9090", i))
+			node.Children = NewPrometheusNodeList(fedsChild11)
+			graph.InsertOrUpdate(node, true)
+			graph.DeleteNodeByHost(fedsChild11[0])
+		}(i)
+		go func() {
+			defer wg.Done()
+			graph.SearchAndUpdateAgentStatus(fedsRoot[0], true, true)
+			graph.SearchAndUpdatePrometheusStatus(fedsRoot[1], true, true)
+			graph.Search(fedsChild11[1], true)
+		}()
+		go func() {
+			defer wg.Done()
+			graph.Ping(time.Second)
+		}()
+		go func() {
+			defer wg.Done()
+			recorder := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", "/graph", nil)
+			getHandler.ServeHTTP(recorder, req)
+			graph.PrintNodesTree("--", 0, true)
+		}()
+		go func(i int) {
+			defer wg.Done()
+			recorder := httptest.NewRecorder()
+			body := fmt.Sprintf(`{"prometheus_host": "%s", "children": [{"prometheus_host": "child-%d:9090"}]}`, fedsRoot[2], i)
+			req, _ := http.NewRequest("POST", "/update-graph", bytes.NewBufferString(body))
+			updateHandler.ServeHTTP(recorder, req)
+		}(i)
+		go func() {
+			defer wg.Done()
+			graph.UpdateChildren(NewPrometheusNodeList(fedsRoot))
+		}()
+	}
+	wg.Wait()
+
+	assert.True(t, graph.Search(fedsRoot[0], false))
+	graph.Read(func(root *PrometheusNode) {
+		assert.True(t, root.AgentStatus)
+		assert.True(t, root.PrometheusStatus)
+	})
+}
@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ANSI escape codes used when tree is rendered with colors
+const (
+	colorReset  = "\033[0m"
+	colorRed    = "\033[31m"
+	colorGreen  = "\033[32m"
+	colorYellow = "\033[33m"
+	colorGray   = "\033[90m"
+)
+
+// TreeOptions control how RenderTree output the nodes
+type TreeOptions struct {
+	// Color wrap the status with ANSI colors
+	Color bool
+	// CollapseHealthy hide the children of node whose subtree
+	// is all healthy, the root is never collapsed
+	CollapseHealthy bool
+}
+
+func statusText(status bool) string {
+	if status == true {
+		return "ok"
+	}
+	return "error"
+}
+
+// Healthy return true if both agent and prometheus are
+// ok and the node is not stale
+func (pn *PrometheusNode) Healthy() bool {
+	return pn.AgentStatus && pn.PrometheusStatus && !pn.Stale
+}
+
+// subtreeHealthy return true if current node and all
+// nodes under it are healthy, size is the nodes under it
+func (pn *PrometheusNode) subtreeHealthy() (healthy bool, size int) {
+	healthy = pn.Healthy()
+	for _, child := range pn.Children {
+		childHealthy, childSize := child.subtreeHealthy()
+		healthy = healthy && childHealthy
+		size += childSize + 1
+	}
+	return healthy, size
+}
+
+// RenderTree render the tree with box-drawing branches, every
+// node shows the status of agent and prometheus, the latency of
+// prometheus health check and whether it is stale
+func (pn *PrometheusNode) RenderTree(options TreeOptions) string {
+	var b strings.Builder
+	b.WriteString(pn.treeLine(options) + "\n")
+	pn.renderChildren(&b, "", options)
+	return b.String()
+}
+
+func (pn *PrometheusNode) renderChildren(b *strings.Builder, prefix string, options TreeOptions) {
+	for index, child := range pn.Children {
+		branch, indent := "├── ", "│   "
+		if index == len(pn.Children)-1 {
+			branch, indent = "└── ", "    "
+		}
+		line := child.treeLine(options)
+		if options.CollapseHealthy {
+			if healthy, size := child.subtreeHealthy(); healthy && size > 0 {
+				b.WriteString(prefix + branch + line + fmt.Sprintf(" (+%d healthy)\n", size))
+				continue
+			}
+		}
+		b.WriteString(prefix + branch + line + "\n")
+		child.renderChildren(b, prefix+indent, options)
+	}
+}
+
+func (pn *PrometheusNode) treeLine(options TreeOptions) string {
+	color := func(text, code string) string {
+		if options.Color {
+			return code + text + colorReset
+		}
+		return text
+	}
+	status := func(name string, ok bool) string {
+		if ok {
+			return color(name+"=ok", colorGreen)
+		}
+		return color(name+"=error", colorRed)
+	}
+	line := pn.PrometheusHost + " [" + status("agent", pn.AgentStatus) + " " + status("prometheus", pn.PrometheusStatus) + "]"
+	if pn.PrometheusStatus && pn.Latency > 0 {
+		line += " " + color(formatLatency(pn.Latency), colorGray)
+	}
+	if pn.Stale {
+		line += " " + color("[stale]", colorYellow)
+	}
+	return line
+}
+
+// formatLatency round the latency for reading
+func formatLatency(latency time.Duration) string {
+	if latency < time.Millisecond {
+		return latency.Round(time.Microsecond).String()
+	}
+	return latency.Round(time.Millisecond).String()
+}
@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func newRenderTree() *PrometheusNode {
+	root := &PrometheusNode{PrometheusHost: "global:9090", AgentStatus: true, PrometheusStatus: true, Latency: 3 * time.Millisecond}
+	healthy := &PrometheusNode{PrometheusHost: "regional-1:9090", AgentStatus: true, PrometheusStatus: true, Latency: 1500 * time.Microsecond}
+	healthy.Children = PrometheusNodeList{
+		{PrometheusHost: "leaf-1:9090", AgentStatus: true, PrometheusStatus: true},
+		{PrometheusHost: "leaf-2:9090", AgentStatus: true, PrometheusStatus: true},
+	}
+	broken := &PrometheusNode{PrometheusHost: "regional-2:9090", PrometheusStatus: true, Latency: 420 * time.Microsecond}
+	broken.Children = PrometheusNodeList{
+		{PrometheusHost: "leaf-3:9090", AgentStatus: true, Stale: true},
+	}
+	root.Children = PrometheusNodeList{healthy, broken}
+	return root
+}
+
+func TestPrometheusNodeRenderTree(t *testing.T) {
+	expect := `global:9090 [agent=ok prometheus=ok] 3ms
+├── regional-1:9090 [agent=ok prometheus=ok] 2ms
+│   ├── leaf-1:9090 [agent=ok prometheus=ok]
+│   └── leaf-2:9090 [agent=ok prometheus=ok]
+└── regional-2:9090 [agent=error prometheus=ok] 420µs
+    └── leaf-3:9090 [agent=ok prometheus=error] [stale]
+`
+	if tree := newRenderTree().RenderTree(TreeOptions{}); tree != expect {
+		t.Fatalf("Expect tree\n%s, but got\n%s", expect, tree)
+	}
+
+	expect = `global:9090 [agent=ok prometheus=ok] 3ms
+├── regional-1:9090 [agent=ok prometheus=ok] 2ms (+2 healthy)
+└── regional-2:9090 [agent=error prometheus=ok] 420µs
+    └── leaf-3:9090 [agent=ok prometheus=error] [stale]
+`
+	if tree := newRenderTree().RenderTree(TreeOptions{CollapseHealthy: true}); tree != expect {
+		t.Fatalf("Expect tree\n%s, but got\n%s", expect, tree)
+	}
+
+	expect = "global:9090 [\033[32magent=ok\033[0m \033[32mprometheus=ok\033[0m] \033[90m3ms\033[0m\n"
+	root := newRenderTree()
+	root.Children = nil
+	if tree := root.RenderTree(TreeOptions{Color: true}); tree != expect {
+		t.Fatalf("Expect tree %q, but got %q", expect, tree)
+	}
+}
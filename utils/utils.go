@@ -1,12 +1,12 @@
 package utils
 
 import (
+	"context"
 	"errors"
-	"io/ioutil"
+	"fmt"
 	"net/http"
 	"strings"
-
-	log "github.com/sirupsen/logrus"
+	"time"
 )
 
 // GetNextProxyHeader will parse the request header and send request
@@ -29,57 +29,44 @@ func GetNextProxyHeader(r *http.Request) ([]string, error) {
 	return []string{nextStop, nextProxyHeader, currentRequestHeader}, nil
 }
 
-// MakeRequest make http request with headers
-func MakeRequest(url string, header map[string]string) (string, error) {
-	log.Infof("Send proxy request to %s", url)
-	log.Infof("Request Header is %v", header)
-	if url == "" {
-		return "", errors.New("url is empty")
+// HostURL add the scheme to host if it has none, http is
+// used when scheme is empty
+func HostURL(scheme string, host string) string {
+	if strings.Contains(host, "://") {
+		return host
 	}
-
-	if !strings.HasPrefix(url, "http://") {
-		url = "http://" + url
+	if scheme == "" {
+		scheme = "http"
 	}
+	return scheme + "://" + host
+}
 
-	client := &http.Client{}
-	req, _ := http.NewRequest("GET", url, nil)
-	for k, v := range header {
-		req.Header.Set(k, v)
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-	return string(body), nil
+// CheckHealth send a GET request to url and return error
+// when the server is unreachable or the response status is not 2xx
+func CheckHealth(url string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return checkHealth(ctx, url)
 }
 
-// MakePrometheusRequest send http request
-func MakePrometheusRequest(r *http.Request) (string, error) {
-	parseResult, err := GetNextProxyHeader(r)
+// checkHealth works like CheckHealth but stops at the deadline of ctx,
+// so many checks can share one deadline
+func checkHealth(ctx context.Context, url string) error {
+	if url == "" {
+		return errors.New("url is empty")
+	}
+	url = HostURL("", url)
+	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return "", err
+		return err
 	}
-	// Direct send request to local prometheus server
-	if parseResult[0] == "" && parseResult[1] == "" && parseResult[2] != "" {
-		resp, err := MakeRequest(parseResult[2], nil)
-		if err != nil {
-			return "", err
-		}
-		return resp, nil
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
 	}
-	if parseResult[0] != "" {
-		resp, err := MakeRequest(parseResult[0], map[string]string{
-			"X-Prometheus-Proxy":   parseResult[1],
-			"X-Prometheus-Request": parseResult[2],
-		})
-		if err != nil {
-			return "", err
-		}
-		return resp, nil
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s return status code %d", url, resp.StatusCode)
 	}
-	return "", errors.New("Http headers unknown")
+	return nil
 }
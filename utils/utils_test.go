@@ -2,8 +2,10 @@ package utils
 
 import (
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestGetNextProxyHeader(t *testing.T) {
@@ -52,3 +54,35 @@ func TestGetNextProxyHeader(t *testing.T) {
 	}
 
 }
+
+func TestHostURL(t *testing.T) {
+	if url := HostURL("", "a.com:9090"); url != "http://a.com:9090" {
+		t.Fatalf("Expect http://a.com:9090, but got %s", url)
+	}
+	if url := HostURL("https", "a.com:9090"); url != "https://a.com:9090" {
+		t.Fatalf("Expect https://a.com:9090, but got %s", url)
+	}
+	if url := HostURL("https", "http://a.com:9090"); url != "http://a.com:9090" {
+		t.Fatalf("Expect http://a.com:9090, but got %s", url)
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/-/healthy" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	if err := CheckHealth(server.URL+"/-/healthy", time.Second); err != nil {
+		t.Fatalf("Expect health check success, but got error %s", err)
+	}
+	if err := CheckHealth(server.URL+"/-/ready", time.Second); err == nil {
+		t.Fatal("Expect health check fail, but got nil")
+	}
+	if err := CheckHealth("", time.Second); err == nil {
+		t.Fatal("Expect error when url is empty, but got nil")
+	}
+}